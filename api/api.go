@@ -0,0 +1,304 @@
+// Package api serves the logs stored by package core, the events of
+// contracts and the progress of their backfills as JSON over HTTP.
+//
+//	GET /contracts/{address}/logs?topic0=&topic1=&topic2=&topic3=&from_block=&to_block=&tx_hash=&order=&page=&page_size=
+//	GET /contracts/{address}/logs?topic0=&topic1=&topic2=&topic3=&from_block=&to_block=&tx_hash=&cursor=&page_size=
+//	GET /contracts/{address}/events
+//	GET /contracts/{address}/status
+//
+// Each topic parameter is a comma-separated list of accepted topics.
+// Logs are the most recent first, unless order is "asc".  With a
+// cursor, even empty for the first page, logs are paged by position
+// rather than offset, and the response holds the cursors of the next
+// (older) and previous (more recent) pages.
+//
+// Errors are reported as {"error": "..."} with a 4xx status for bad
+// input and unknown contracts.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/blocksignalio/core"
+)
+
+const (
+	// DefaultPageSize is the number of logs returned when the request
+	// does not say.
+	DefaultPageSize = 100
+	// DefaultMaxPageSize caps the page_size of a request.
+	DefaultMaxPageSize = 1000
+)
+
+var errBadParameter = errors.New("bad parameter")
+
+// Server is the HTTP handler of the API.
+type Server struct {
+	// DB only needs to be read, e.g. as connected by core.ConnectURL.
+	DB *gorm.DB
+	// Cache looks up the events of contracts.
+	Cache       *core.ABICache
+	MaxPageSize int
+	mux         *http.ServeMux
+}
+
+func NewServer(db *gorm.DB, cache *core.ABICache) *Server {
+	s := &Server{
+		DB:          db,
+		Cache:       cache,
+		MaxPageSize: DefaultMaxPageSize,
+		mux:         http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /contracts/{address}/logs", s.handleLogs)
+	s.mux.HandleFunc("GET /contracts/{address}/events", s.handleEvents)
+	s.mux.HandleFunc("GET /contracts/{address}/status", s.handleStatus)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// +----------+
+// | Handlers |
+// +----------+
+
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !core.ValidateAddress(address) {
+		writeError(w, fmt.Errorf("%w: %s", core.ErrInvalidContractAddress, address))
+		return
+	}
+	filter, err := s.logFilter(address, r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	db := s.DB.WithContext(r.Context())
+
+	if r.URL.Query().Has("cursor") {
+		page, err := filter.FindAfter(db, r.URL.Query().Get("cursor"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"logs":     page.Logs,
+			"next":     page.Next,
+			"prev":     page.Prev,
+			"pageSize": filter.PageSize,
+		})
+		return
+	}
+
+	logs, err := filter.Find(db)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"logs":     logs,
+		"page":     filter.Page,
+		"pageSize": filter.PageSize,
+	})
+}
+
+// logFilter reads the parameters of a request for the logs of
+// `address`, and validates them before the database is queried.
+func (s *Server) logFilter(address string, query url.Values) (core.LogFilter, error) {
+	filter := core.LogFilter{
+		Addresses: []string{address},
+		Topics:    nil,
+		FromBlock: 0,
+		ToBlock:   0,
+		TxHash:    query.Get("tx_hash"),
+		Order:     core.NewestFirst,
+		Page:      0,
+		PageSize:  0,
+	}
+	for i, name := range []string{"topic0", "topic1", "topic2", "topic3"} {
+		value := query.Get(name)
+		if value != "" {
+			filter.Topics = append(filter.Topics, make([][]string, i+1-len(filter.Topics))...)
+			filter.Topics[i] = strings.Split(value, ",")
+		}
+	}
+
+	var err error
+	filter.FromBlock, err = uintParam(query.Get("from_block"), 0)
+	if err != nil {
+		return filter, fmt.Errorf("from_block: %w", err)
+	}
+	filter.ToBlock, err = uintParam(query.Get("to_block"), 0)
+	if err != nil {
+		return filter, fmt.Errorf("to_block: %w", err)
+	}
+	page, err := uintParam(query.Get("page"), 0)
+	if err != nil {
+		return filter, fmt.Errorf("page: %w", err)
+	}
+	if page > math.MaxInt {
+		return filter, fmt.Errorf("page: %w: %d", core.ErrPageOutOfRange, page)
+	}
+	filter.Page = int(page)
+	pageSize, err := uintParam(query.Get("page_size"), DefaultPageSize)
+	if err != nil || pageSize == 0 || pageSize > uint64(s.MaxPageSize) {
+		return filter, fmt.Errorf("%w: page_size: want 1 to %d", errBadParameter, s.MaxPageSize)
+	}
+	filter.PageSize = int(pageSize)
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Order = core.OldestFirst
+	default:
+		return filter, fmt.Errorf("%w: order: want asc or desc", errBadParameter)
+	}
+
+	if query.Has("cursor") {
+		if filter.Page != 0 || filter.Order != core.NewestFirst {
+			return filter, fmt.Errorf("%w: cursor excludes page and order", errBadParameter)
+		}
+		cursor := query.Get("cursor")
+		if cursor != "" {
+			_, err = core.ParseLogCursor(cursor)
+			if err != nil {
+				return filter, err
+			}
+		}
+	}
+	return filter, filter.Validate()
+}
+
+type argument struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Indexed bool   `json:"indexed"`
+}
+
+type event struct {
+	Name      string     `json:"name"`
+	Signature string     `json:"signature"`
+	Topic0    string     `json:"topic0"`
+	Anonymous bool       `json:"anonymous"`
+	Inputs    []argument `json:"inputs"`
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !core.ValidateAddress(address) {
+		writeError(w, fmt.Errorf("%w: %s", core.ErrInvalidContractAddress, address))
+		return
+	}
+
+	events, err := s.Cache.GetContractEvents(r.Context(), address)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	xs := make([]event, 0, len(events))
+	for _, e := range events {
+		inputs := make([]argument, len(e.Inputs))
+		for i, input := range e.Inputs {
+			inputs[i] = argument{Name: input.Name, Type: input.Type.String(), Indexed: input.Indexed}
+		}
+		xs = append(xs, event{
+			Name:      e.Name,
+			Signature: e.Sig,
+			Topic0:    e.ID.Hex(),
+			Anonymous: e.Anonymous,
+			Inputs:    inputs,
+		})
+	}
+	sort.Slice(xs, func(i, j int) bool { return xs[i].Name < xs[j].Name })
+	writeJSON(w, http.StatusOK, map[string]any{"events": xs})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !core.ValidateAddress(address) {
+		writeError(w, fmt.Errorf("%w: %s", core.ErrInvalidContractAddress, address))
+		return
+	}
+
+	states, err := core.LoadBackfillStates(r.Context(), s.DB, address)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(states) == 0 {
+		writeError(w, fmt.Errorf("%w: no backfill of %s", core.ErrNotFound, address))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"backfills": states})
+}
+
+// +---------+
+// | Helpers |
+// +---------+
+
+func uintParam(value string, otherwise uint64) (uint64, error) {
+	if value == "" {
+		return otherwise, nil
+	}
+	x, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is not a non-negative integer", errBadParameter, value)
+	}
+	return x, nil
+}
+
+// statusCode maps an error to the status of the response.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, errBadParameter),
+		errors.Is(err, core.ErrInvalidContractAddress),
+		errors.Is(err, core.ErrInvalidTopic),
+		errors.Is(err, core.ErrTooManyTopics),
+		errors.Is(err, core.ErrNegativePage),
+		errors.Is(err, core.ErrPageOutOfRange),
+		errors.Is(err, core.ErrInvalidCursor),
+		errors.Is(err, core.ErrInvalidTxHash),
+		errors.Is(err, core.ErrInvalidBlockRange):
+		return http.StatusBadRequest
+	case errors.Is(err, core.ErrNotFound),
+		errors.Is(err, core.ErrContractNotVerified):
+		return http.StatusNotFound
+	case errors.Is(err, core.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, core.ErrInvalidAPIKey),
+		errors.Is(err, core.ErrInvalidResponse),
+		errors.Is(err, core.ErrInvalidResponseBody):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := statusCode(err)
+	message := err.Error()
+	if code == http.StatusInternalServerError {
+		// Don't leak database errors, but keep them for the operator.
+		slog.Error("internal error", "err", err)
+		message = http.StatusText(code)
+	}
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
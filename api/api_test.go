@@ -0,0 +1,264 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+
+	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/api"
+	"github.com/blocksignalio/core/coretest"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	const unverified = "0x0000000000000000000000000000000000000001"
+
+	client := coretest.NewEtherscan().Client(t)
+	cache := core.NewABICache(client, core.NewDirStore(t.TempDir()), 8)
+	// Requests that reach the database are not tested here.
+	server := httptest.NewServer(api.NewServer(nil, cache))
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/contracts/" + coretest.WETH + "/events", http.StatusOK},
+		{"/contracts/" + unverified + "/events", http.StatusNotFound},
+		{"/contracts/0x1234/events", http.StatusBadRequest},
+		{"/contracts/0x1234/logs", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?topic0=0x1234", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?from_block=-1", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?page_size=0", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?page_size=1001", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?page=9223372036854775807", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?page=18446744073709551615", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?cursor=!", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?topic2=0x1234", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?tx_hash=0x1234", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?from_block=2&to_block=1", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?order=up", http.StatusBadRequest},
+		{"/contracts/" + coretest.WETH + "/logs?cursor=&page=1", http.StatusBadRequest},
+		{"/contracts/0x1234/status", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		response, err := http.Get(server.URL + test.path) //nolint:noctx
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]any
+		err = json.NewDecoder(response.Body).Decode(&body)
+		response.Body.Close()
+		if err != nil {
+			t.Errorf("%s: decode: %v", test.path, err)
+		}
+		if response.StatusCode != test.code {
+			t.Errorf("%s: status: have=%d want=%d body=%v", test.path, response.StatusCode, test.code, body)
+		}
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	t.Parallel()
+
+	client := coretest.NewEtherscan().Client(t)
+	cache := core.NewABICache(client, core.NewDirStore(t.TempDir()), 8)
+	server := httptest.NewServer(api.NewServer(nil, cache))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/contracts/" + coretest.WETH + "/events") //nolint:noctx
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	var body struct {
+		Events []struct {
+			Name   string `json:"name"`
+			Topic0 string `json:"topic0"`
+		} `json:"events"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(body.Events))
+	for _, e := range body.Events {
+		names = append(names, e.Name)
+	}
+	want := []string{"Approval", "Deposit", "Transfer", "Withdrawal"}
+	if len(names) != len(want) {
+		t.Fatalf("events: have=%v want=%v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("events: have=%v want=%v", names, want)
+		}
+	}
+	if have := body.Events[2].Topic0; have != "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" {
+		t.Errorf("Transfer topic0: have=%s", have)
+	}
+}
+
+// getJSON decodes the response to a GET of `url` into `v`, and returns
+// its status.
+func getJSON(t *testing.T, url string, v any) int {
+	t.Helper()
+	response, err := http.Get(url) //nolint:noctx
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	err = json.NewDecoder(response.Body).Decode(v)
+	if err != nil {
+		t.Fatalf("%s: decode: %v", url, err)
+	}
+	return response.StatusCode
+}
+
+// newDBServer serves a database holding a log of WETH at each of
+// `blocks`.
+func newDBServer(t *testing.T, blocks ...uint64) (*gorm.DB, *httptest.Server) {
+	t.Helper()
+	db := coretest.DB(t)
+	for _, block := range blocks {
+		x := core.FromGethLog(coretest.MakeLog(coretest.WETH, block, 0))
+		err := db.Create(&x).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := coretest.NewEtherscan().Client(t)
+	cache := core.NewABICache(client, core.NewDBStore(db), 8)
+	server := httptest.NewServer(api.NewServer(db, cache))
+	t.Cleanup(server.Close)
+	return db, server
+}
+
+type logsBody struct {
+	Logs []core.Log `json:"logs"`
+	Page int        `json:"page"`
+	Next string     `json:"next"`
+	Prev string     `json:"prev"`
+}
+
+func (b logsBody) blocks() []uint64 {
+	xs := make([]uint64, len(b.Logs))
+	for i, x := range b.Logs {
+		xs[i] = x.BlockNumber
+	}
+	return xs
+}
+
+func TestServerLogs(t *testing.T) {
+	t.Parallel()
+
+	_, server := newDBServer(t, 1, 2, 3, 4, 5)
+	base := server.URL + "/contracts/" + coretest.WETH + "/logs"
+
+	tests := []struct {
+		query string
+		want  []uint64
+	}{
+		{"", []uint64{5, 4, 3, 2, 1}},
+		{"?page_size=2", []uint64{5, 4}},
+		{"?page_size=2&page=1", []uint64{3, 2}},
+		{"?page_size=2&page=2", []uint64{1}},
+		{"?page_size=2&page=3", []uint64{}},
+		{"?page_size=2&page=1&order=asc", []uint64{3, 4}},
+		{"?from_block=2&to_block=4", []uint64{4, 3, 2}},
+	}
+	for _, test := range tests {
+		var body logsBody
+		if code := getJSON(t, base+test.query, &body); code != http.StatusOK {
+			t.Errorf("%q: status: have=%d want=%d", test.query, code, http.StatusOK)
+			continue
+		}
+		if diff := cmp.Diff(test.want, body.blocks()); diff != "" {
+			t.Errorf("%q: blocks (-want +have):\n%s", test.query, diff)
+		}
+	}
+}
+
+func TestServerLogsCursor(t *testing.T) {
+	t.Parallel()
+
+	_, server := newDBServer(t, 1, 2, 3, 4, 5)
+	base := server.URL + "/contracts/" + coretest.WETH + "/logs?page_size=2&cursor="
+
+	// Older pages down to the oldest log.
+	var (
+		pages  [][]uint64
+		cursor string
+		last   logsBody
+	)
+	for range 3 {
+		var body logsBody
+		if code := getJSON(t, base+cursor, &body); code != http.StatusOK {
+			t.Fatalf("%q: status: have=%d want=%d", cursor, code, http.StatusOK)
+		}
+		pages = append(pages, body.blocks())
+		cursor, last = body.Next, body
+	}
+	if diff := cmp.Diff([][]uint64{{5, 4}, {3, 2}, {1}}, pages); diff != "" {
+		t.Errorf("pages (-want +have):\n%s", diff)
+	}
+	if last.Next != "" {
+		t.Errorf("next of the oldest page: have=%q want=none", last.Next)
+	}
+
+	// And back.
+	var body logsBody
+	if code := getJSON(t, base+last.Prev, &body); code != http.StatusOK {
+		t.Fatalf("%q: status: have=%d want=%d", last.Prev, code, http.StatusOK)
+	}
+	if diff := cmp.Diff([]uint64{3, 2}, body.blocks()); diff != "" {
+		t.Errorf("previous page (-want +have):\n%s", diff)
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+	t.Parallel()
+
+	db, server := newDBServer(t)
+	state := core.BackfillState{ //nolint:exhaustruct
+		ChainID:       1,
+		Address:       strings.ToLower(coretest.WETH),
+		CreationBlock: coretest.WETHCreationBlock,
+		LastBlock:     coretest.WETHCreationBlock + 10,
+		LastBlockHash: "0x01",
+		Status:        core.BackfillStatusSynced,
+	}
+	err := db.Create(&state).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct {
+		Backfills []core.BackfillState `json:"backfills"`
+	}
+	if code := getJSON(t, server.URL+"/contracts/"+coretest.WETH+"/status", &body); code != http.StatusOK {
+		t.Fatalf("status: have=%d want=%d", code, http.StatusOK)
+	}
+	if len(body.Backfills) != 1 {
+		t.Fatalf("backfills: have=%d want=1", len(body.Backfills))
+	}
+	have := body.Backfills[0]
+	if have.LastBlock != state.LastBlock || have.Status != state.Status {
+		t.Errorf("backfill: have=%d/%s want=%d/%s", have.LastBlock, have.Status, state.LastBlock, state.Status)
+	}
+
+	// Contracts never backfilled are not found.
+	const other = "0x0000000000000000000000000000000000000001"
+	var missing map[string]any
+	if code := getJSON(t, server.URL+"/contracts/"+other+"/status", &missing); code != http.StatusNotFound {
+		t.Errorf("status of %s: have=%d want=%d", other, code, http.StatusNotFound)
+	}
+}
@@ -2,65 +2,557 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/crypto"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func retrieveFromBlock(ctx context.Context, db *gorm.DB, contract string) (uint64, error) {
-	var last []Log
-	result := db.Distinct("address", "block_number", "index").
-		Where("address = ?", prepareHex(contract)).
-		Order("block_number desc, index desc").
-		Limit(1).
-		Find(&last)
-	if result.Error != nil {
-		return 0, fmt.Errorf("find: %w", result.Error)
+// +-------------+
+// | backfillEnv |
+// +-------------+
+
+// backfillEnv holds the clients a backfill works with, so that they can
+// be shared by the contracts of a Backfiller.
+type backfillEnv struct {
+	db        *gorm.DB
+	node      *Node
+	etherscan *EtherscanClient
+	cache     *ABICache
+	opts      BackfillOptions
+	// nodeSlots bounds the number of requests in flight to the node.
+	// If nil, requests are not limited.
+	nodeSlots chan struct{}
+	// progress, if not nil, is called after each batch.
+	progress func(BackfillProgress)
+}
+
+func (e *backfillEnv) acquire(ctx context.Context) (func(), error) {
+	if e.nodeSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case e.nodeSlots <- struct{}{}:
+		return func() { <-e.nodeSlots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("context done: %w", ctx.Err())
+	}
+}
+
+func (e *backfillEnv) chainID(ctx context.Context) (uint64, error) {
+	release, err := e.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	return e.node.ChainID(ctx)
+}
+
+func (e *backfillEnv) blockHash(ctx context.Context, number uint64) (string, error) {
+	release, err := e.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return e.node.BlockHash(ctx, number)
+}
+
+func (e *backfillEnv) transactionBlock(ctx context.Context, tx string) (uint64, error) {
+	release, err := e.acquire(ctx)
+	if err != nil {
+		return 0, err
 	}
-	if len(last) > 0 {
-		fromBlock := last[0].BlockNumber + 1
-		return fromBlock, nil
+	defer release()
+	return e.node.TransactionBlock(ctx, tx)
+}
+
+func (e *backfillEnv) queryLogPage(ctx context.Context, q LogQuery) (LogPage, error) {
+	release, err := e.acquire(ctx)
+	if err != nil {
+		var empty LogPage
+		return empty, err
 	}
-	creation, err := GetContractCreation1(contract)
+	defer release()
+	return e.node.QueryLogPage(ctx, q)
+}
+
+func (e *backfillEnv) head(ctx context.Context) (uint64, error) {
+	release, err := e.acquire(ctx)
 	if err != nil {
 		return 0, err
 	}
-	return GetTransactionBlock(ctx, creation.TxHash)
+	defer release()
+	return e.node.Head(ctx)
+}
+
+func (e *backfillEnv) report(p BackfillProgress) {
+	if e.progress != nil {
+		e.progress(p)
+	}
+}
+
+// events returns the events used to decode the logs of `contract`:
+// those of its ABI if verified, the standard ones otherwise.  Decoding
+// is a bonus, so if the ABI cannot be fetched, e.g. because Etherscan is
+// down, the failure is logged and the standard events are used; the
+// rest of the logs are stored undecoded, for RedecodeLogs.
+func (e *backfillEnv) events(ctx context.Context, contract string) (map[string]abi.Event, error) {
+	events, err := e.cache.GetContractEvents(ctx, contract)
+	if err == nil {
+		return events, nil
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	if !errors.Is(err, ErrContractNotVerified) {
+		slog.WarnContext(ctx, "abi unavailable, decoding standard events only", "contract", contract, "err", err)
+	}
+	return StandardEvents(), nil
+}
+
+// eventSet holds the events used to decode the logs of each contract of
+// a backfill, by address.
+type eventSet map[string]map[string]abi.Event
+
+func (s eventSet) decode(xs []Log) []LogDecoding {
+	ys := make([]LogDecoding, 0, len(xs))
+	for _, x := range xs {
+		y, ok := decodeLog(x, s[x.Address])
+		if ok {
+			ys = append(ys, y)
+		}
+	}
+	return ys
+}
+
+// eventSet returns the events of each of `contracts`.
+func (e *backfillEnv) eventSet(ctx context.Context, contracts []string) (eventSet, error) {
+	s := make(eventSet, len(contracts))
+	for _, contract := range contracts {
+		events, err := e.events(ctx, contract)
+		if err != nil {
+			return nil, err
+		}
+		s[prepareHex(contract)] = events
+	}
+	return s, nil
+}
+
+func storeDecodings(db *gorm.DB, xs []LogDecoding) error {
+	if len(xs) == 0 {
+		return nil
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&xs) //nolint:exhaustruct
+	if result.Error != nil {
+		return fmt.Errorf("create decodings: %w", result.Error)
+	}
+	return nil
+}
+
+// DefaultConfirmations is the number of blocks a log must have on top
+// of it before it is backfilled.
+const DefaultConfirmations = 64
+
+// BackfillOptions tunes BackfillLogsWithOptions.
+type BackfillOptions struct {
+	// Confirmations is the number of most recent blocks left out of
+	// the backfill because they may still be reorganized.
+	Confirmations uint64
+	// Shards, if greater than one, splits the range left to backfill
+	// into that many shards scanned concurrently by ShardWorkers
+	// goroutines.  This pays off for busy contracts with a long
+	// history.  The shards are recorded, so an interrupted backfill
+	// resumes them exactly.
+	Shards       int
+	ShardWorkers int
+	// Topics restricts the backfill to matching logs; see LogQuery.
+	// Each distinct filter keeps its own checkpoint.
+	Topics [][]string
+}
+
+func DefaultBackfillOptions() BackfillOptions {
+	return BackfillOptions{
+		Confirmations: DefaultConfirmations,
+		Shards:        0,
+		ShardWorkers:  0,
+		Topics:        nil,
+	}
+}
+
+// BlockRangeError reports the range of blocks, inclusive, whose logs
+// could not be stored.  Nothing from the range was committed.
+type BlockRangeError struct {
+	Contract  string
+	FromBlock uint64
+	ToBlock   uint64
+	Err       error
+}
+
+func (e *BlockRangeError) Error() string {
+	return fmt.Sprintf("store %s blocks [%d, %d]: %v", e.Contract, e.FromBlock, e.ToBlock, e.Err)
+}
+
+func (e *BlockRangeError) Unwrap() error {
+	return e.Err
+}
+
+// storeLogs inserts the logs, skipping those already stored, and sets
+// their IDs.  IDs are looked up afterwards because rows skipped by ON
+// CONFLICT DO NOTHING are not returned by the insert.
+func storeLogs(tx *gorm.DB, xs []Log) error {
+	if len(xs) == 0 {
+		return nil
+	}
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&xs) //nolint:exhaustruct
+	if result.Error != nil {
+		return fmt.Errorf("create logs: %w", result.Error)
+	}
+
+	type key struct {
+		address     string
+		blockNumber uint64
+		index       uint
+	}
+	lo, hi := xs[0].BlockNumber, xs[0].BlockNumber
+	for _, x := range xs {
+		lo, hi = min(lo, x.BlockNumber), max(hi, x.BlockNumber)
+	}
+	var stored []Log
+	result = tx.Select("id", "address", "block_number", "index").
+		Where("address IN ?", logAddresses(xs)).
+		Where("block_number BETWEEN ? AND ?", lo, hi).
+		Find(&stored)
+	if result.Error != nil {
+		return fmt.Errorf("find logs: %w", result.Error)
+	}
+	ids := make(map[key]uint64, len(stored))
+	for _, x := range stored {
+		ids[key{x.Address, x.BlockNumber, x.Index}] = x.ID
+	}
+	for i, x := range xs {
+		xs[i].ID = ids[key{x.Address, x.BlockNumber, x.Index}]
+	}
+	return nil
+}
+
+func logAddresses(xs []Log) []string {
+	seen := make(map[string]bool)
+	ys := make([]string, 0, 1)
+	for _, x := range xs {
+		if !seen[x.Address] {
+			seen[x.Address] = true
+			ys = append(ys, x.Address)
+		}
+	}
+	return ys
+}
+
+// storeBatch stores the logs of a scanned range, their decodings and the
+// checkpoint.  It is meant to run in a transaction.
+func storeBatch(ctx context.Context, tx *gorm.DB, xs []Log, events eventSet, state BackfillState) error {
+	err := storeLogs(tx, xs)
+	if err != nil {
+		return err
+	}
+	err = storeDecodings(tx, events.decode(xs))
+	if err != nil {
+		return err
+	}
+	return saveState(ctx, tx, state)
+}
+
+// newEnv connects to the node at ETHEREUM_NODE and to Etherscan with
+// the key at ETHERSCAN_APIKEY.  ABIs are cached in `db`, so that they
+// are fetched once across runs.  The caller closes the node.
+func newEnv(ctx context.Context, db *gorm.DB, opts BackfillOptions) (*backfillEnv, error) {
+	client, err := NewEtherscanClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	node, err := DialEnv(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cache := NewABICache(client, NewDBStore(db), DefaultCacheSize)
+	return &backfillEnv{
+		db:        db,
+		node:      node,
+		etherscan: client,
+		cache:     cache,
+		opts:      opts,
+		nodeSlots: nil,
+		progress:  nil,
+	}, nil
 }
 
-// TODO: Query up to head-64 (?) to retrieve only finalized logs?  And
-// then check types.Log.Removed to confirm everything was OK!
 func BackfillLogs(ctx context.Context, db *gorm.DB, contract string) error {
+	return BackfillLogsWithOptions(ctx, db, contract, DefaultBackfillOptions())
+}
+
+// BackfillLogsWithOptions stores the logs of `contract` up to
+// `opts.Confirmations` blocks behind head, resuming from its
+// BackfillState.  Before resuming, it checks that the last block
+// scanned is still canonical and rolls back the logs of reorganized
+// blocks.
+func BackfillLogsWithOptions(ctx context.Context, db *gorm.DB, contract string, opts BackfillOptions) error {
 	if !ValidateAddress(contract) {
 		return makeErrorHex(ErrInvalidContractAddress, contract)
 	}
 
-	fromBlock, err := retrieveFromBlock(ctx, db, contract)
+	env, err := newEnv(ctx, db, opts)
 	if err != nil {
 		return err
 	}
+	defer env.node.Close()
 
-	for {
-		toBlock, xs, err := QueryLogs(ctx, fromBlock, contract)
+	return env.backfill(ctx, contract)
+}
+
+// BackfillGroup is like BackfillLogsWithOptions, but backfills the
+// logs of all of `contracts` together, e.g. the pools deployed by a
+// factory.  Each range is fetched with a single eth_getLogs call for the
+// whole group, which shares one checkpoint, keyed by GroupKey, starting
+// at the creation of its oldest contract.  Adding a contract to the
+// group makes it a different group, backfilled from scratch.
+func BackfillGroup(ctx context.Context, db *gorm.DB, contracts []string, opts BackfillOptions) error {
+	err := validateGroup(contracts)
+	if err != nil {
+		return err
+	}
+
+	env, err := newEnv(ctx, db, opts)
+	if err != nil {
+		return err
+	}
+	defer env.node.Close()
+
+	return env.backfillGroup(ctx, contracts)
+}
+
+func validateGroup(contracts []string) error {
+	for _, contract := range contracts {
+		if !ValidateAddress(contract) {
+			return makeErrorHex(ErrInvalidContractAddress, contract)
+		}
+	}
+	if len(contracts) == 0 {
+		return makeErrorHex(ErrInvalidContractAddress, "")
+	}
+	return nil
+}
+
+// GroupKey returns the key of the checkpoint of a group of contracts:
+// the address of a lone contract, so that it shares the checkpoint of
+// BackfillLogs, and otherwise the Keccak-256 hash of the sorted,
+// lowercase addresses.  It also returns those addresses.
+func GroupKey(contracts []string) (string, []string) {
+	seen := make(map[string]bool, len(contracts))
+	addresses := make([]string, 0, len(contracts))
+	for _, contract := range contracts {
+		address := prepareHex(contract)
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	if len(addresses) == 1 {
+		return addresses[0], addresses
+	}
+	return crypto.Keccak256Hash([]byte(strings.Join(addresses, ","))).Hex(), addresses
+}
+
+// prepare loads, or creates, the checkpoint of the group of `addresses`
+// and makes sure it is still on the canonical chain.  It also returns
+// the events used to decode their logs.
+func (e *backfillEnv) prepare(ctx context.Context, key string, addresses []string) (BackfillState, eventSet, error) {
+	var empty BackfillState
+
+	topics, err := NormalizeTopics(e.opts.Topics)
+	if err != nil {
+		return empty, nil, err
+	}
+
+	chainID, err := e.chainID(ctx)
+	if err != nil {
+		return empty, nil, err
+	}
+	err = checkChain(ctx, e.db, chainID)
+	if err != nil {
+		return empty, nil, err
+	}
+
+	state, ok, err := loadState(ctx, e.db, chainID, key, topicsKey(topics))
+	if err != nil {
+		return state, nil, err
+	}
+	if !ok {
+		state, err = e.initState(ctx, chainID, key, addresses, topicsKey(topics))
+		if err != nil {
+			return state, nil, err
+		}
+	}
+	state.Addresses = strings.Join(addresses, ",")
+
+	state, err = e.checkReorg(ctx, state, addresses)
+	if err != nil {
+		return state, nil, err
+	}
+
+	events, err := e.eventSet(ctx, addresses)
+	if err != nil {
+		return state, nil, err
+	}
+
+	return state, events, nil
+}
+
+func (e *backfillEnv) backfill(ctx context.Context, contract string) error {
+	return e.backfillGroup(ctx, []string{contract})
+}
+
+func (e *backfillEnv) backfillGroup(ctx context.Context, contracts []string) error {
+	return e.backfillSharded(ctx, contracts, e.opts.Shards, max(1, e.opts.ShardWorkers))
+}
+
+// backfillSharded splits the range left to backfill into `shards`
+// shards processed by `workers` goroutines, then backfills the rest of
+// the way sequentially.  Shards left over by an interrupted run are
+// always completed first, even if `shards` is zero.
+func (e *backfillEnv) backfillSharded(ctx context.Context, contracts []string, shards, workers int) (err error) {
+	db := e.db
+
+	key, addresses := GroupKey(contracts)
+	state, events, err := e.prepare(ctx, key, addresses)
+	if err != nil {
+		return err
+	}
+
+	// An interrupted backfill stays "running"; anything else that
+	// stops it is recorded.
+	defer func() {
+		if err != nil && ctx.Err() == nil {
+			state.Status = BackfillStatusFailed
+			_ = saveState(context.WithoutCancel(ctx), db, state)
+		}
+	}()
+
+	pending, err := loadShards(ctx, db, state)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 && shards > 1 {
+		pending, err = e.planShards(ctx, state, shards)
 		if err != nil {
 			return err
 		}
+	}
+	if len(pending) > 0 {
+		state, err = e.runShards(ctx, state, pending, addresses, events, workers)
+		if err != nil {
+			return err
+		}
+	}
 
-		ys := adaptLogs(xs)
-		db.Create(&ys)
+	for {
+		page, err := e.queryLogPage(ctx, LogQuery{
+			FromBlock:     state.resumeBlock(),
+			ToBlock:       0,
+			Contract:      "",
+			Addresses:     addresses,
+			Topics:        parseTopicsKey(state.Topics),
+			Confirmations: e.opts.Confirmations,
+		})
+		if err != nil {
+			return err
+		}
+		if page.Blocks() == 0 {
+			break
+		}
 
-		// fmt.Printf(
-		// 	"Backfill: querying: fromBlock=%d toBlock=%d result=%d\n",
-		// 	fromBlock,
-		// 	toBlock,
-		// 	len(ys),
-		// )
+		// Checkpoint the last block scanned, atomically with its logs.
+		hash, err := e.blockHash(ctx, page.ToBlock)
+		if err != nil {
+			return err
+		}
+		next := state
+		next.LastBlock = page.ToBlock
+		next.LastBlockHash = hash
+		next.Status = BackfillStatusRunning
+		ys := adaptLogs(page.Logs)
+		err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return storeBatch(ctx, tx, ys, events, next)
+		})
+		if err != nil {
+			return &BlockRangeError{
+				Contract:  state.Address,
+				FromBlock: page.FromBlock,
+				ToBlock:   page.ToBlock,
+				Err:       err,
+			}
+		}
+		state = next
 
-		if len(xs) > 0 {
-			fromBlock = toBlock
-		} else {
+		e.report(BackfillProgress{
+			Contract:  state.Address,
+			FromBlock: page.FromBlock,
+			ToBlock:   page.ToBlock,
+			Logs:      len(ys),
+			Done:      false,
+			Err:       nil,
+		})
+		if page.CaughtUp {
 			break
 		}
 	}
-	return nil
+
+	state.Status = BackfillStatusSynced
+	return saveState(ctx, db, state)
+}
+
+// RedecodeLogs decodes the stored logs of `contract` that have no
+// LogDecoding yet, e.g. because they were ingested before its ABI was
+// known, and returns how many were decoded.  Logs that `events` cannot
+// decode are left as they are.
+func RedecodeLogs(ctx context.Context, db *gorm.DB, contract string, events map[string]abi.Event) (int, error) {
+	const batchSize = 1024
+
+	if !ValidateAddress(contract) {
+		return 0, makeErrorHex(ErrInvalidContractAddress, contract)
+	}
+
+	var (
+		total  int
+		lastID uint64
+	)
+	for {
+		var xs []Log
+		result := db.WithContext(ctx).
+			Where("address = ? AND id > ?", prepareHex(contract), lastID).
+			Where("NOT EXISTS (SELECT 1 FROM log_decodings d WHERE d.log_id = logs.id)").
+			Order("id").
+			Limit(batchSize).
+			Find(&xs)
+		if result.Error != nil {
+			return total, fmt.Errorf("find: %w", result.Error)
+		}
+		if len(xs) == 0 {
+			return total, nil
+		}
+
+		ys := decodeLogs(xs, events)
+		err := storeDecodings(db.WithContext(ctx), ys)
+		if err != nil {
+			return total, err
+		}
+		total += len(ys)
+		lastID = xs[len(xs)-1].ID
+	}
 }
@@ -0,0 +1,230 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+
+	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/coretest"
+)
+
+const transferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// fixture backfills contracts of a fake chain, with a fake Etherscan,
+// into a database of its own.
+type fixture struct {
+	db         *gorm.DB
+	chain      *coretest.Chain
+	etherscan  *coretest.Etherscan
+	backfiller *core.Backfiller
+}
+
+// newFixture returns a fixture whose chain is at WETHCreationBlock plus
+// `blocks`.  Nothing is left out for confirmations.  The test is
+// skipped if DATABASE_URL is not set.
+func newFixture(t *testing.T, blocks uint64) fixture {
+	t.Helper()
+	db := coretest.DB(t)
+	chain := coretest.NewChain(1, coretest.WETHCreationBlock+blocks)
+	chain.AddTransaction(coretest.WETHCreationTx, coretest.WETHCreationBlock)
+	etherscan := coretest.NewEtherscan()
+	cache := core.NewABICache(etherscan.Client(t), core.NewDBStore(db), core.DefaultCacheSize)
+	backfiller := core.NewBackfiller(db, chain.Node(t), cache)
+	backfiller.Options.Confirmations = 0
+	return fixture{db: db, chain: chain, etherscan: etherscan, backfiller: backfiller}
+}
+
+// run backfills `contracts` and fails the test on error.
+func (f fixture) run(t *testing.T, contracts ...string) {
+	t.Helper()
+	for _, result := range f.backfiller.Run(context.Background(), contracts) {
+		if result.Err != nil {
+			t.Fatalf("%s: %v", result.Contract, result.Err)
+		}
+	}
+}
+
+// logs returns the positions of the stored logs as "block/index".
+func (f fixture) logs(t *testing.T) []string {
+	t.Helper()
+	var xs []core.Log
+	err := f.db.Order("block_number, index").Find(&xs).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	ys := make([]string, len(xs))
+	for i, x := range xs {
+		ys[i] = position(x.BlockNumber, x.Index)
+	}
+	return ys
+}
+
+func (f fixture) count(t *testing.T, model any) int64 {
+	t.Helper()
+	var n int64
+	err := f.db.Model(model).Count(&n).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func (f fixture) state(t *testing.T, key string) core.BackfillState {
+	t.Helper()
+	var state core.BackfillState
+	err := f.db.Where("address = ? AND topics = ''", key).Take(&state).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// failDecoding makes the decodings of the logs at `block` fail to be
+// stored, until heal is called.
+func (f fixture) failDecoding(t *testing.T, block uint64) {
+	t.Helper()
+	err := f.db.Exec(`CREATE FUNCTION fail_decoding() RETURNS trigger AS $$
+BEGIN
+	IF (SELECT block_number FROM logs WHERE id = NEW.log_id) = ` + strconv.FormatUint(block, 10) + ` THEN
+		RAISE EXCEPTION 'failing decoding';
+	END IF;
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.db.Exec("CREATE TRIGGER fail_decoding BEFORE INSERT ON log_decodings FOR EACH ROW EXECUTE FUNCTION fail_decoding()").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f fixture) heal(t *testing.T) {
+	t.Helper()
+	err := f.db.Exec("DROP TRIGGER fail_decoding ON log_decodings").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// transfer returns a WETH Transfer log at WETHCreationBlock plus
+// `block`.
+func transfer(block uint64, index uint) types.Log {
+	return transferOf(coretest.WETH, block, index)
+}
+
+func transferOf(contract string, block uint64, index uint) types.Log {
+	from := common.BytesToHash(common.FromHex("0x01")).Hex()
+	to := common.BytesToHash(common.FromHex("0x02")).Hex()
+	x := coretest.MakeLog(contract, coretest.WETHCreationBlock+block, index, transferTopic, from, to)
+	x.Data = common.LeftPadBytes(big.NewInt(int64(block)).Bytes(), 32)
+	return x
+}
+
+func position(block uint64, index uint) string {
+	return fmt.Sprintf("%d/%d", block-coretest.WETHCreationBlock, index)
+}
+
+func TestGroupKey(t *testing.T) {
+	t.Parallel()
+
+	const (
+		weth = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+		usdc = "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	)
+
+	key, addresses := core.GroupKey([]string{weth, weth})
+	if want := "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"; key != want {
+		t.Errorf("lone key: have=%s want=%s", key, want)
+	}
+	if diff := cmp.Diff([]string{key}, addresses); diff != "" {
+		t.Errorf("lone addresses (-want +have):\n%s", diff)
+	}
+
+	a, xs := core.GroupKey([]string{weth, usdc})
+	b, ys := core.GroupKey([]string{usdc, weth, usdc})
+	if a != b {
+		t.Errorf("order matters: %s != %s", a, b)
+	}
+	if !core.ValidateTopic(a) {
+		t.Errorf("group key: have=%s want a 32-byte hash", a)
+	}
+	if diff := cmp.Diff(xs, ys); diff != "" {
+		t.Errorf("group addresses (-a +b):\n%s", diff)
+	}
+}
+
+func TestBackfillWithoutABI(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t, 10)
+	f.chain.AddLogs(transfer(1, 0), transfer(2, 0), transfer(2, 1))
+	f.etherscan.Fail("getabi", "Max rate limit reached")
+
+	// Logs are stored anyway, and decoded with the standard events.
+	f.run(t, coretest.WETH)
+	if diff := cmp.Diff([]string{"1/0", "2/0", "2/1"}, f.logs(t)); diff != "" {
+		t.Errorf("logs (-want +have):\n%s", diff)
+	}
+	if have, want := f.count(t, &core.LogDecoding{}), int64(3); have != want { //nolint:exhaustruct
+		t.Errorf("decodings: have=%d want=%d", have, want)
+	}
+	// The failure is not cached.
+	if have := f.count(t, &core.ContractABI{}); have != 0 { //nolint:exhaustruct
+		t.Errorf("cached abis: have=%d want=0", have)
+	}
+}
+
+func TestBackfillBatchFailure(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t, 10)
+	f.chain.SetMaxRange(4)
+	f.chain.AddLogs(transfer(1, 0), transfer(5, 0), transfer(6, 0), transfer(9, 0))
+
+	// Decoding the log at +6 fails, after the logs of its batch were
+	// inserted.
+	failing := uint64(coretest.WETHCreationBlock + 6)
+	f.failDecoding(t, failing)
+
+	results := f.backfiller.Run(context.Background(), []string{coretest.WETH})
+	var rangeErr *core.BlockRangeError
+	if !errors.As(results[0].Err, &rangeErr) {
+		t.Fatalf("error: have=%v want a BlockRangeError", results[0].Err)
+	}
+	if rangeErr.FromBlock > failing || rangeErr.ToBlock < failing {
+		t.Errorf("range: have=[%d, %d] want it to hold %d", rangeErr.FromBlock, rangeErr.ToBlock, failing)
+	}
+
+	// Nothing of the failed batch is left, and the checkpoint stops
+	// right before it.
+	var want []string
+	for _, x := range []types.Log{transfer(1, 0), transfer(5, 0)} {
+		if x.BlockNumber < rangeErr.FromBlock {
+			want = append(want, position(x.BlockNumber, x.Index))
+		}
+	}
+	if diff := cmp.Diff(want, f.logs(t)); diff != "" {
+		t.Errorf("logs (-want +have):\n%s", diff)
+	}
+	if have, want := f.count(t, &core.LogDecoding{}), int64(len(want)); have != want { //nolint:exhaustruct
+		t.Errorf("decodings: have=%d want=%d", have, want)
+	}
+	state := f.state(t, wethKey)
+	if have, want := state.LastBlock, rangeErr.FromBlock-1; have != want {
+		t.Errorf("checkpoint: have=%d want=%d", have, want)
+	}
+	if state.Status != core.BackfillStatusFailed {
+		t.Errorf("status: have=%s want=%s", state.Status, core.BackfillStatusFailed)
+	}
+}
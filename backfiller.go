@@ -0,0 +1,157 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultBackfillWorkers is the number of contracts a Backfiller
+// processes concurrently unless told otherwise.
+const DefaultBackfillWorkers = 8
+
+// BackfillProgress is reported by a Backfiller after each batch of logs
+// is stored, and once more when a contract is done.
+type BackfillProgress struct {
+	// Contract is the address of the contract, or the GroupKey of the
+	// group, being backfilled.
+	Contract string
+	// Range of blocks, inclusive, covered by the batch.  Zero in the
+	// final report.
+	FromBlock uint64
+	ToBlock   uint64
+	// Number of logs in the batch.
+	Logs int
+	// Done is set in the final report, along with Err if the backfill
+	// of the contract failed.
+	Done bool
+	Err  error
+}
+
+// BackfillResult is the outcome of the backfill of a single contract.
+type BackfillResult struct {
+	Contract string
+	Err      error
+}
+
+// Backfiller backfills many contracts concurrently, sharing a single
+// connection to the node and a single Etherscan client.  Each batch is
+// committed along with its checkpoint, so cancelling the context passed
+// to Run loses no progress.
+type Backfiller struct {
+	DB      *gorm.DB
+	Node    *Node
+	Cache   *ABICache
+	Options BackfillOptions
+	// Workers is the number of contracts backfilled concurrently.
+	Workers int
+	// NodeConcurrency bounds the number of requests in flight to the
+	// node across all workers.  Zero means Workers.  Etherscan
+	// requests are bounded by the rate limit of the Cache's client.
+	NodeConcurrency int
+	// Progress, if not nil, is called from the workers, and so must be
+	// safe for concurrent use.
+	Progress func(BackfillProgress)
+}
+
+func NewBackfiller(db *gorm.DB, node *Node, cache *ABICache) *Backfiller {
+	return &Backfiller{
+		DB:              db,
+		Node:            node,
+		Cache:           cache,
+		Options:         DefaultBackfillOptions(),
+		Workers:         DefaultBackfillWorkers,
+		NodeConcurrency: 0,
+		Progress:        nil,
+	}
+}
+
+// Run backfills `contracts` and returns one result per contract, in the
+// same order.  Contracts not started when the context is done report
+// the context's error.
+func (b *Backfiller) Run(ctx context.Context, contracts []string) []BackfillResult {
+	workers := max(1, b.Workers)
+	env := b.env(workers)
+
+	results := make([]BackfillResult, len(contracts))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				err := b.backfill(ctx, env, contracts[i])
+				results[i] = BackfillResult{Contract: contracts[i], Err: err}
+			}
+		}()
+	}
+
+	for i := range contracts {
+		results[i] = BackfillResult{Contract: contracts[i], Err: nil}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// BackfillGroup is like the package-level BackfillGroup, but uses the
+// clients and options of the Backfiller.
+func (b *Backfiller) BackfillGroup(ctx context.Context, contracts []string) error {
+	err := validateGroup(contracts)
+	if err != nil {
+		return err
+	}
+	return b.env(1).backfillGroup(ctx, contracts)
+}
+
+// Follow is like the package-level Follow, but uses the clients and
+// options of the Backfiller.
+func (b *Backfiller) Follow(ctx context.Context, contract string, interval time.Duration) error {
+	if !ValidateAddress(contract) {
+		return makeErrorHex(ErrInvalidContractAddress, contract)
+	}
+	return b.env(1).follow(ctx, contract, interval)
+}
+
+func (b *Backfiller) env(workers int) *backfillEnv {
+	slots := b.NodeConcurrency
+	if slots <= 0 {
+		slots = workers
+	}
+	return &backfillEnv{
+		db:        b.DB,
+		node:      b.Node,
+		etherscan: b.Cache.Client,
+		cache:     b.Cache,
+		opts:      b.Options,
+		nodeSlots: make(chan struct{}, slots),
+		progress:  b.Progress,
+	}
+}
+
+func (b *Backfiller) backfill(ctx context.Context, env *backfillEnv, contract string) error {
+	var err error
+	if !ValidateAddress(contract) {
+		err = makeErrorHex(ErrInvalidContractAddress, contract)
+	} else {
+		err = env.backfill(ctx, contract)
+	}
+	env.report(BackfillProgress{
+		Contract:  prepareHex(contract),
+		FromBlock: 0,
+		ToBlock:   0,
+		Logs:      0,
+		Done:      true,
+		Err:       err,
+	})
+	return err
+}
@@ -0,0 +1,101 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/coretest"
+)
+
+// unknown is a contract the fake Etherscan knows nothing about.
+const unknown = "0x000000000000000000000000000000000000dEaD"
+
+func TestBackfillerErrors(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t, 10)
+	f.chain.AddLogs(transfer(1, 0), transfer(2, 0))
+	f.backfiller.Workers = 2
+	var (
+		mu   sync.Mutex
+		done int
+	)
+	f.backfiller.Progress = func(p core.BackfillProgress) {
+		if p.Done {
+			mu.Lock()
+			done++
+			mu.Unlock()
+		}
+	}
+
+	// A contract failing does not stop the others.
+	contracts := []string{"nonsense", coretest.WETH, unknown}
+	results := f.backfiller.Run(context.Background(), contracts)
+	for i, result := range results {
+		if result.Contract != contracts[i] {
+			t.Errorf("result %d: have=%s want=%s", i, result.Contract, contracts[i])
+		}
+	}
+	if err := results[0].Err; !errors.Is(err, core.ErrInvalidContractAddress) {
+		t.Errorf("invalid address: have=%v want=%v", err, core.ErrInvalidContractAddress)
+	}
+	if err := results[1].Err; err != nil {
+		t.Errorf("weth: have=%v want=<nil>", err)
+	}
+	if err := results[2].Err; err == nil {
+		t.Errorf("unknown contract: have=<nil> want an error")
+	}
+	if done != len(contracts) {
+		t.Errorf("final reports: have=%d want=%d", done, len(contracts))
+	}
+	if diff := cmp.Diff([]string{"1/0", "2/0"}, f.logs(t)); diff != "" {
+		t.Errorf("logs (-want +have):\n%s", diff)
+	}
+}
+
+func TestBackfillerCancel(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t, 10)
+	f.chain.SetMaxRange(4)
+	f.chain.AddLogs(transfer(1, 0), transfer(6, 0))
+	f.backfiller.Workers = 1
+
+	// Stop after the first batch.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var first core.BackfillProgress
+	f.backfiller.Progress = func(p core.BackfillProgress) {
+		if !p.Done && first.ToBlock == 0 {
+			first = p
+			cancel()
+		}
+	}
+
+	results := f.backfiller.Run(ctx, []string{coretest.WETH, unknown})
+	if err := results[0].Err; !errors.Is(err, context.Canceled) {
+		t.Errorf("interrupted: have=%v want=%v", err, context.Canceled)
+	}
+	if err := results[1].Err; !errors.Is(err, context.Canceled) {
+		t.Errorf("not started: have=%v want=%v", err, context.Canceled)
+	}
+
+	// The batch committed is kept, and the backfill resumes after it.
+	state := f.state(t, wethKey)
+	if state.LastBlock < first.ToBlock {
+		t.Errorf("checkpoint: have=%d want at least %d", state.LastBlock, first.ToBlock)
+	}
+	if state.Status != core.BackfillStatusRunning {
+		t.Errorf("status: have=%s want=%s", state.Status, core.BackfillStatusRunning)
+	}
+	f.backfiller.Progress = nil
+	f.run(t, coretest.WETH)
+	if diff := cmp.Diff([]string{"1/0", "6/0"}, f.logs(t)); diff != "" {
+		t.Errorf("logs (-want +have):\n%s", diff)
+	}
+}
@@ -1,13 +1,351 @@
 package core
 
 import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/ethereum/go-ethereum/accounts/abi"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultCacheSize is the number of parsed ABIs kept in memory.
+	DefaultCacheSize = 1024
+
+	// DefaultNegativeTTL is how long a contract found to be unverified
+	// is remembered before Etherscan is asked again.
+	DefaultNegativeTTL = 24 * time.Hour
 )
 
-// (address string) (map[string]abi.Event, error) {
-func GetContractEventsCached(address string) (map[string]abi.Event, error) {
+// +----------+
+// | ABIStore |
+// +----------+
+
+// ABIStore persists the raw ABIs fetched from Etherscan.  LoadABI
+// returns ErrCacheMiss when nothing is stored for the address.
+// Addresses are always passed in lowercase.
+type ABIStore interface {
+	LoadABI(ctx context.Context, address string) (ContractABI, error)
+	StoreABI(ctx context.Context, entry ContractABI) error
+}
+
+// DBStore keeps ABIs in the `contract_abis` table.
+type DBStore struct {
+	DB *gorm.DB
+}
+
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{DB: db}
+}
+
+func (s *DBStore) LoadABI(ctx context.Context, address string) (ContractABI, error) {
+	var xs []ContractABI
+	result := s.DB.WithContext(ctx).Where("address = ?", address).Limit(1).Find(&xs)
+	if result.Error != nil {
+		return ContractABI{}, fmt.Errorf("find: %w", result.Error) //nolint:exhaustruct
+	}
+	if len(xs) == 0 {
+		return ContractABI{}, ErrCacheMiss //nolint:exhaustruct
+	}
+	return xs[0], nil
+}
+
+func (s *DBStore) StoreABI(ctx context.Context, entry ContractABI) error {
+	result := s.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}). //nolint:exhaustruct
+		Create(&entry)
+	if result.Error != nil {
+		return fmt.Errorf("create: %w", result.Error)
+	}
+	return nil
+}
+
+// DirStore keeps ABIs as JSON files in a directory, one per contract.
+type DirStore struct {
+	Dir string
+}
+
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{Dir: dir}
+}
+
+func (s *DirStore) path(address string) string {
+	return filepath.Join(s.Dir, address+".json")
+}
+
+func (s *DirStore) LoadABI(_ context.Context, address string) (ContractABI, error) {
+	var entry ContractABI
+	content, err := os.ReadFile(s.path(address))
+	if errors.Is(err, os.ErrNotExist) {
+		return entry, ErrCacheMiss
+	}
+	if err != nil {
+		return entry, fmt.Errorf("read: %w", err)
+	}
+	err = json.Unmarshal(content, &entry)
+	if err != nil {
+		return entry, fmt.Errorf("unmarshal: %w", err)
+	}
+	return entry, nil
+}
+
+func (s *DirStore) StoreABI(_ context.Context, entry ContractABI) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	err = os.MkdirAll(s.Dir, 0o755) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	// Write to a temporary file first so that readers never see a
+	// partially written entry.
+	tmp, err := os.CreateTemp(s.Dir, entry.Address+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	err = os.Rename(tmp.Name(), s.path(entry.Address))
+	if err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// +-----+
+// | LRU |
+// +-----+
+
+type cacheItem struct {
+	address string
+	entry   ContractABI
+	parsed  abi.ABI
+}
+
+type lru struct {
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *lru) get(address string) (cacheItem, bool) {
+	e, ok := c.items[address]
+	if !ok {
+		var empty cacheItem
+		return empty, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(cacheItem), true //nolint:forcetypeassert
+}
+
+func (c *lru) put(item cacheItem) {
+	if e, ok := c.items[item.address]; ok {
+		e.Value = item
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[item.address] = c.order.PushFront(item)
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(cacheItem).address) //nolint:forcetypeassert
+	}
+}
+
+// +----------+
+// | ABICache |
+// +----------+
+
+// ABICache sits in front of an EtherscanClient.  Lookups go through an
+// in-memory LRU, then through the optional persistent Store, and only
+// then to Etherscan.  ABIs of verified contracts never change, so they
+// are kept forever; unverified contracts are remembered for
+// NegativeTTL.
+type ABICache struct {
+	Client      *EtherscanClient
+	Store       ABIStore
+	NegativeTTL time.Duration
+
+	mu     sync.Mutex
+	memory *lru
+}
+
+// NewABICache returns a cache keeping up to `size` ABIs in memory.
+// `store` may be nil, in which case nothing is persisted.
+func NewABICache(client *EtherscanClient, store ABIStore, size int) *ABICache {
+	return &ABICache{
+		Client:      client,
+		Store:       store,
+		NegativeTTL: DefaultNegativeTTL,
+		mu:          sync.Mutex{},
+		memory:      newLRU(max(1, size)),
+	}
+}
+
+func (c *ABICache) fresh(entry ContractABI) bool {
+	return entry.Verified || time.Since(entry.FetchedAt) < c.NegativeTTL
+}
+
+func (c *ABICache) remember(item cacheItem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.memory.put(item)
+}
+
+func (c *ABICache) recall(address string) (cacheItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.memory.get(address)
+	if !ok || !c.fresh(item.entry) {
+		return item, false
+	}
+	return item, true
+}
+
+func (c *ABICache) load(ctx context.Context, address string) (cacheItem, error) {
+	// Memory.
+	if item, ok := c.recall(address); ok {
+		return item, nil
+	}
+
+	// Persistent store.
+	if c.Store != nil {
+		entry, err := c.Store.LoadABI(ctx, address)
+		if err != nil && !errors.Is(err, ErrCacheMiss) {
+			return cacheItem{}, fmt.Errorf("load: %w", err) //nolint:exhaustruct
+		}
+		if err == nil && c.fresh(entry) {
+			item, err := makeCacheItem(entry)
+			if err != nil {
+				return item, err
+			}
+			c.remember(item)
+			return item, nil
+		}
+	}
+
+	// Etherscan.
+	raw, err := c.Client.GetContractRawABI(ctx, address)
+	if err != nil && !errors.Is(err, ErrContractNotVerified) {
+		return cacheItem{}, err //nolint:exhaustruct
+	}
+	entry := ContractABI{
+		Address:   address,
+		ABI:       raw,
+		Verified:  err == nil,
+		FetchedAt: time.Now().UTC(),
+	}
+	item, err := makeCacheItem(entry)
+	if err != nil {
+		return item, err
+	}
+	if c.Store != nil {
+		err = c.Store.StoreABI(ctx, entry)
+		if err != nil {
+			return item, fmt.Errorf("store: %w", err)
+		}
+	}
+	c.remember(item)
+	return item, nil
+}
+
+func makeCacheItem(entry ContractABI) (cacheItem, error) {
+	item := cacheItem{address: entry.Address, entry: entry, parsed: abi.ABI{}} //nolint:exhaustruct
+	if !entry.Verified {
+		return item, nil
+	}
+	parsed, err := abi.JSON(strings.NewReader(entry.ABI))
+	if err != nil {
+		return item, fmt.Errorf("read json: %w", err)
+	}
+	item.parsed = parsed
+	return item, nil
+}
+
+func (c *ABICache) GetContractABI(ctx context.Context, address string) (abi.ABI, error) {
+	if !ValidateAddress(address) {
+		return abi.ABI{}, makeErrorHex(ErrInvalidContractAddress, address) //nolint:exhaustruct
+	}
+	item, err := c.load(ctx, prepareHex(address))
+	if err != nil {
+		return item.parsed, err
+	}
+	// Whether the contract was just fetched or cached as unverified,
+	// report it the way GetContractSource does.
+	if !item.entry.Verified {
+		return item.parsed, notVerifiedError("Contract source code not verified")
+	}
+	return item.parsed, nil
+}
+
+func (c *ABICache) GetContractEvents(ctx context.Context, address string) (map[string]abi.Event, error) {
+	iface, err := c.GetContractABI(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return iface.Events, nil
+}
+
+// +--------+
+// | Public |
+// +--------+
+
+//nolint:gochecknoglobals
+var (
+	defaultCacheMu sync.Mutex
+	defaultCache   *ABICache
+)
+
+func getDefaultCache() (*ABICache, error) {
+	defaultCacheMu.Lock()
+	defer defaultCacheMu.Unlock()
+	if defaultCache == nil {
+		client, err := NewEtherscanClientFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		defaultCache = NewABICache(client, nil, DefaultCacheSize)
+	}
+	return defaultCache, nil
+}
+
+// GetContractEventsCached is like GetContractEvents, but goes through a
+// process-wide, memory-only ABICache.  Use an ABICache with a Store to
+// keep ABIs across runs.
+func GetContractEventsCached(ctx context.Context, address string) (map[string]abi.Event, error) {
 	if !ValidateAddress(address) {
 		return nil, makeErrorHex(ErrInvalidContractAddress, address)
 	}
-	return GetContractEvents(address)
+	cache, err := getDefaultCache()
+	if err != nil {
+		return nil, err
+	}
+	return cache.GetContractEvents(ctx, address)
 }
@@ -0,0 +1,97 @@
+package core_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/blocksignalio/core"
+)
+
+func TestABICache(t *testing.T) {
+	t.Parallel()
+
+	const (
+		weth       = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+		unverified = "0x0000000000000000000000000000000000000001"
+		result     = `[{"anonymous":false,"inputs":[` +
+			`{"indexed":true,"name":"dst","type":"address"},` +
+			`{"indexed":false,"name":"wad","type":"uint256"}],` +
+			`"name":"Deposit","type":"event"}]`
+	)
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body := map[string]string{"status": "1", "message": "OK", "result": result}
+		if r.URL.Query().Get("address") == unverified {
+			body = map[string]string{"status": "0", "message": "NOTOK", "result": "Contract source code not verified"}
+		}
+		content, _ := json.Marshal(body)
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	client := core.NewEtherscanClient("TESTKEY")
+	client.BaseURL = server.URL
+	client.RateLimit = 0
+	store := core.NewDirStore(t.TempDir())
+	ctx := context.Background()
+
+	// The first lookup hits Etherscan, the second hits memory.
+	cache := core.NewABICache(client, store, 8)
+	for range 2 {
+		events, err := cache.GetContractEvents(ctx, weth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := events["Deposit"]; !ok {
+			t.Errorf("events: have=%v want=Deposit", events)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("calls: have=%d want=1", calls)
+	}
+
+	// A fresh cache on the same store does not hit Etherscan.
+	cache = core.NewABICache(client, store, 8)
+	_, err := cache.GetContractEvents(ctx, weth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("calls: have=%d want=1", calls)
+	}
+
+	// Unverified contracts are negatively cached until the TTL expires.
+	for range 2 {
+		_, err = cache.GetContractEvents(ctx, unverified)
+		if !errors.Is(err, core.ErrContractNotVerified) {
+			t.Errorf("error: have=%v want=%v", err, core.ErrContractNotVerified)
+		}
+		var e *core.EtherscanError
+		if !errors.As(err, &e) {
+			t.Errorf("EtherscanError: have=%#v", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("calls: have=%d want=2", calls)
+	}
+	// So they are across caches on the same store.
+	_, err = core.NewABICache(client, store, 8).GetContractEvents(ctx, unverified)
+	var e *core.EtherscanError
+	if !errors.As(err, &e) || !errors.Is(err, core.ErrContractNotVerified) {
+		t.Errorf("error: have=%#v want=%v", err, core.ErrContractNotVerified)
+	}
+	if calls != 2 {
+		t.Errorf("calls: have=%d want=2", calls)
+	}
+	cache.NegativeTTL = 0
+	_, _ = cache.GetContractEvents(ctx, unverified)
+	if calls != 3 {
+		t.Errorf("calls: have=%d want=3", calls)
+	}
+}
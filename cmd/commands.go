@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+
+	"github.com/blocksignalio/core"
+)
+
+// Etherscan looks up at most this many contracts per creation call.
+const creationBatchSize = 5
+
+// +----------+
+// | backfill |
+// +----------+
+
+func cmdBackfill(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var (
+		c       config
+		opts    = core.DefaultBackfillOptions()
+		workers int
+		topics  topicsFlag
+	)
+	fs := newFlagSet("backfill", "<address...>", &c, stderr)
+	fs.Uint64Var(&opts.Confirmations, "confirmations", opts.Confirmations, "blocks left out behind head")
+	fs.IntVar(&opts.Shards, "shards", opts.Shards, "shards the range left to backfill is split into")
+	fs.IntVar(&opts.ShardWorkers, "shard-workers", opts.ShardWorkers, "shards of a contract scanned concurrently")
+	fs.IntVar(&workers, "workers", core.DefaultBackfillWorkers, "contracts backfilled concurrently")
+	fs.Var(&topics, "topic", "accepted topics of the next position, comma-separated; repeat for each position")
+	contracts, err := parse(fs, &c, args, 1, -1)
+	if err != nil {
+		return err
+	}
+	opts.Topics = topics
+
+	backfiller, closeNode, err := c.backfiller(ctx, opts, stderr)
+	if err != nil {
+		return err
+	}
+	defer closeNode()
+	backfiller.Workers = workers
+
+	results := backfiller.Run(ctx, contracts)
+	t := table{
+		value:  nil,
+		header: []string{"CONTRACT", "RESULT"},
+		rows:   make([][]string, 0, len(results)),
+	}
+	type jsonResult struct {
+		Contract string `json:"contract"`
+		Error    string `json:"error,omitempty"`
+	}
+	values := make([]jsonResult, 0, len(results))
+	errs := make([]error, 0, len(results))
+	for _, result := range results {
+		status, message := "ok", ""
+		if result.Err != nil {
+			status, message = result.Err.Error(), result.Err.Error()
+			errs = append(errs, result.Err)
+		}
+		t.rows = append(t.rows, []string{result.Contract, status})
+		values = append(values, jsonResult{Contract: result.Contract, Error: message})
+	}
+	t.value = values
+
+	err = c.print(stdout, t)
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// backfiller connects to the database, the node and Etherscan.  The
+// function returned closes the node.
+func (c *config) backfiller(ctx context.Context, opts core.BackfillOptions, stderr io.Writer) (*core.Backfiller, func(), error) {
+	db, err := c.openDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := c.etherscanClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	node, err := c.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cache := core.NewABICache(client, core.NewDBStore(db), core.DefaultCacheSize)
+	backfiller := core.NewBackfiller(db, node, cache)
+	backfiller.Options = opts
+	backfiller.Progress = func(p core.BackfillProgress) {
+		switch {
+		case p.Done && p.Err != nil:
+			fmt.Fprintf(stderr, "%s: failed: %v\n", p.Contract, p.Err)
+		case p.Done:
+			fmt.Fprintf(stderr, "%s: done\n", p.Contract)
+		default:
+			fmt.Fprintf(stderr, "%s: blocks [%d, %d]: %d logs\n", p.Contract, p.FromBlock, p.ToBlock, p.Logs)
+		}
+	}
+	return backfiller, node.Close, nil
+}
+
+// +--------+
+// | follow |
+// +--------+
+
+func cmdFollow(ctx context.Context, args []string, _, stderr io.Writer) error {
+	var (
+		c      config
+		opts   = core.DefaultFollowOptions()
+		topics topicsFlag
+	)
+	fs := newFlagSet("follow", "<address>", &c, stderr)
+	fs.Uint64Var(&opts.Backfill.Confirmations, "confirmations", opts.Backfill.Confirmations, "blocks left out behind head")
+	fs.DurationVar(&opts.PollInterval, "poll-interval", opts.PollInterval, "how often to poll, or move the checkpoint")
+	fs.Var(&topics, "topic", "accepted topics of the next position, comma-separated; repeat for each position")
+	rest, err := parse(fs, &c, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	opts.Backfill.Topics = topics
+
+	backfiller, closeNode, err := c.backfiller(ctx, opts.Backfill, stderr)
+	if err != nil {
+		return err
+	}
+	defer closeNode()
+
+	err = backfiller.Follow(ctx, rest[0], opts.PollInterval)
+	if ctx.Err() != nil {
+		// Interrupted; every batch stored is checkpointed.
+		return nil
+	}
+	return err
+}
+
+// +------+
+// | logs |
+// +------+
+
+// logsArgs are the arguments of the logs command.
+type logsArgs struct {
+	config   config
+	filter   core.LogFilter
+	count    bool
+	byCursor bool
+	cursor   string
+}
+
+// parseLogs parses the arguments of the logs command, and checks the
+// filter, before anything is connected to.
+func parseLogs(args []string, stderr io.Writer) (logsArgs, error) {
+	var (
+		x      logsArgs
+		topics topicsFlag
+		asc    bool
+	)
+	fs := newFlagSet("logs", "<address...>", &x.config, stderr)
+	fs.Var(&topics, "topic", "accepted topics of the next position, comma-separated; repeat for each position")
+	fs.Uint64Var(&x.filter.FromBlock, "from-block", 0, "only logs from this block on")
+	fs.Uint64Var(&x.filter.ToBlock, "to-block", 0, "only logs up to this block, or 0 for all")
+	fs.StringVar(&x.filter.TxHash, "tx", "", "only logs of this transaction")
+	fs.BoolVar(&asc, "asc", false, "oldest logs first")
+	fs.BoolVar(&x.count, "count", false, "print the number of logs instead")
+	fs.IntVar(&x.filter.Page, "page", 0, "page, from 0")
+	fs.IntVar(&x.filter.PageSize, "page-size", 100, "logs per page, or 0 for all")
+	fs.StringVar(&x.cursor, "cursor", "", "page by cursor, from the one printed with the previous page, or empty for the first")
+	rest, err := parse(fs, &x.config, args, 1, -1)
+	if err != nil {
+		return x, err
+	}
+	x.filter.Addresses = rest
+	x.filter.Topics = topics
+	if asc {
+		x.filter.Order = core.OldestFirst
+	}
+	fs.Visit(func(f *flag.Flag) { x.byCursor = x.byCursor || f.Name == "cursor" })
+	if x.byCursor && (x.filter.Page != 0 || asc) {
+		return x, fmt.Errorf("%w: -cursor excludes -page and -asc", errUsage)
+	}
+	err = x.filter.Validate()
+	if err != nil {
+		return x, err
+	}
+	return x, nil
+}
+
+func cmdLogs(_ context.Context, args []string, stdout, stderr io.Writer) error {
+	parsed, err := parseLogs(args, stderr)
+	if err != nil {
+		return err
+	}
+	c, filter := parsed.config, parsed.filter
+
+	db, err := c.connectDB()
+	if err != nil {
+		return err
+	}
+	if parsed.count {
+		n, err := filter.Count(db)
+		if err != nil {
+			return err
+		}
+		return c.print(stdout, table{
+			value:  map[string]int64{"count": n},
+			header: []string{"COUNT"},
+			rows:   [][]string{{strconv.FormatInt(n, 10)}},
+		})
+	}
+	var (
+		logs  []core.Log
+		value any
+	)
+	if parsed.byCursor {
+		p, err := filter.FindAfter(db, parsed.cursor)
+		if err != nil {
+			return err
+		}
+		logs, value = p.Logs, p
+		// Cursors go to stderr so that the table stays a table.
+		if c.format == formatTable {
+			fmt.Fprintf(stderr, "next: %s\nprev: %s\n", p.Next, p.Prev)
+		}
+	} else {
+		logs, err = filter.Find(db)
+		if err != nil {
+			return err
+		}
+		value = logs
+	}
+
+	t := table{
+		value:  value,
+		header: []string{"ADDRESS", "BLOCK", "INDEX", "TX", "TOPIC0"},
+		rows:   make([][]string, 0, len(logs)),
+	}
+	for _, x := range logs {
+		t.rows = append(t.rows, []string{
+			x.Address,
+			strconv.FormatUint(x.BlockNumber, 10),
+			strconv.FormatUint(uint64(x.Index), 10),
+			x.TxHash,
+			x.Topic0,
+		})
+	}
+	return c.print(stdout, t)
+}
+
+// +-----+
+// | abi |
+// +-----+
+
+func cmdABI(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var c config
+	fs := newFlagSet("abi", "<address>", &c, stderr)
+	rest, err := parse(fs, &c, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.etherscanClient()
+	if err != nil {
+		return err
+	}
+	raw, err := client.GetContractRawABI(ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	parsed, err := abi.JSON(strings.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("read json: %w", err)
+	}
+
+	t := table{
+		value:  json.RawMessage(raw),
+		header: []string{"TYPE", "SELECTOR", "SIGNATURE"},
+		rows:   make([][]string, 0, len(parsed.Methods)+len(parsed.Events)),
+	}
+	for _, method := range sortedValues(parsed.Methods) {
+		t.rows = append(t.rows, []string{"function", fmt.Sprintf("0x%x", method.ID), method.Sig})
+	}
+	for _, event := range sortedValues(parsed.Events) {
+		t.rows = append(t.rows, []string{"event", event.ID.Hex(), event.Sig})
+	}
+	return c.print(stdout, t)
+}
+
+// +--------+
+// | source |
+// +--------+
+
+func cmdSource(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var c config
+	fs := newFlagSet("source", "<address>", &c, stderr)
+	rest, err := parse(fs, &c, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.etherscanClient()
+	if err != nil {
+		return err
+	}
+	sources, err := client.GetContractSource(ctx, rest[0])
+	if err != nil {
+		return err
+	}
+
+	t := table{
+		value:  sources,
+		header: []string{"NAME", "COMPILER", "OPTIMIZED", "RUNS", "LICENSE", "PROXY", "IMPLEMENTATION"},
+		rows:   make([][]string, 0, len(sources)),
+	}
+	for _, s := range sources {
+		t.rows = append(t.rows, []string{
+			s.ContractName,
+			s.CompilerVersion,
+			s.OptimizationUsed,
+			s.Runs,
+			s.LicenseType,
+			s.Proxy,
+			s.Implementation,
+		})
+	}
+	return c.print(stdout, t)
+}
+
+// +----------+
+// | creation |
+// +----------+
+
+func cmdCreation(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var c config
+	fs := newFlagSet("creation", "<address...>", &c, stderr)
+	contracts, err := parse(fs, &c, args, 1, -1)
+	if err != nil {
+		return err
+	}
+	for _, contract := range contracts {
+		if !core.ValidateAddress(contract) {
+			return fmt.Errorf("%w: %s", core.ErrInvalidContractAddress, contract)
+		}
+	}
+
+	client, err := c.etherscanClient()
+	if err != nil {
+		return err
+	}
+	creations := make([]core.ContractCreation, 0, len(contracts))
+	for i := 0; i < len(contracts); i += creationBatchSize {
+		xs, err := client.GetContractCreation(ctx, contracts[i:min(i+creationBatchSize, len(contracts))])
+		if err != nil {
+			return err
+		}
+		creations = append(creations, xs...)
+	}
+
+	t := table{
+		value:  creations,
+		header: []string{"CONTRACT", "CREATOR", "TX"},
+		rows:   make([][]string, 0, len(creations)),
+	}
+	for _, x := range creations {
+		t.rows = append(t.rows, []string{x.ContractAddress, x.ContractCreator, x.TxHash})
+	}
+	return c.print(stdout, t)
+}
+
+// +--------+
+// | events |
+// +--------+
+
+func cmdEvents(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var c config
+	fs := newFlagSet("events", "<address>", &c, stderr)
+	rest, err := parse(fs, &c, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.etherscanClient()
+	if err != nil {
+		return err
+	}
+	events, err := client.GetContractEvents(ctx, rest[0])
+	if err != nil {
+		return err
+	}
+
+	type jsonEvent struct {
+		Name      string `json:"name"`
+		Signature string `json:"signature"`
+		Topic0    string `json:"topic0"`
+		Anonymous bool   `json:"anonymous"`
+	}
+	sorted := sortedValues(events)
+	values := make([]jsonEvent, 0, len(sorted))
+	t := table{
+		value:  nil,
+		header: []string{"NAME", "TOPIC0", "SIGNATURE"},
+		rows:   make([][]string, 0, len(sorted)),
+	}
+	for _, event := range sorted {
+		values = append(values, jsonEvent{
+			Name:      event.Name,
+			Signature: event.Sig,
+			Topic0:    event.ID.Hex(),
+			Anonymous: event.Anonymous,
+		})
+		t.rows = append(t.rows, []string{event.Name, event.ID.Hex(), event.Sig})
+	}
+	t.value = values
+	return c.print(stdout, t)
+}
+
+// sortedValues returns the values of `m` ordered by key.
+func sortedValues[T any](m map[string]T) []T {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	xs := make([]T, 0, len(keys))
+	for _, key := range keys {
+		xs = append(xs, m[key])
+	}
+	return xs
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gorm.io/gorm"
+
+	"github.com/blocksignalio/core"
+)
+
+var errUsage = errors.New("bad usage")
+
+const (
+	envDatabaseURL  = "DATABASE_URL"
+	envEthereumNode = "ETHEREUM_NODE"
+	envEtherscanKey = "ETHERSCAN_APIKEY"
+
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// +--------+
+// | config |
+// +--------+
+
+// config holds the flags common to all commands.  Each defaults to its
+// environment variable.
+type config struct {
+	database  string
+	node      string
+	etherscan string
+	format    string
+}
+
+func newFlagSet(name, args string, c *config, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: main %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&c.database, "database", os.Getenv(envDatabaseURL), "Postgres `URL`")
+	fs.StringVar(&c.node, "node", os.Getenv(envEthereumNode), "Ethereum JSON-RPC `URL`")
+	fs.StringVar(&c.etherscan, "etherscan", os.Getenv(envEtherscanKey), "Etherscan API `key`")
+	fs.StringVar(&c.format, "format", formatTable, `output format, "table" or "json"`)
+	return fs
+}
+
+// parse parses flags wherever they are among the arguments, checks the
+// number of arguments left, and returns them.
+func parse(fs *flag.FlagSet, c *config, args []string, minArgs, maxArgs int) ([]string, error) {
+	var rest []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %w", errUsage, err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+
+	if c.format != formatTable && c.format != formatJSON {
+		return nil, fmt.Errorf("%w: unknown format %q", errUsage, c.format)
+	}
+	if len(rest) < minArgs || (maxArgs >= 0 && len(rest) > maxArgs) {
+		fs.Usage()
+		return nil, fmt.Errorf("%w: wrong number of arguments", errUsage)
+	}
+	return rest, nil
+}
+
+// openDB connects to the database and migrates its schema, for the
+// commands that write.
+func (c *config) openDB() (*gorm.DB, error) {
+	if c.database == "" {
+		return nil, fmt.Errorf("%w: %s", core.ErrUnsetEnvironmentVar, envDatabaseURL)
+	}
+	return core.OpenURL(c.database)
+}
+
+// connectDB connects to the database as is, for the commands that only
+// read.
+func (c *config) connectDB() (*gorm.DB, error) {
+	if c.database == "" {
+		return nil, fmt.Errorf("%w: %s", core.ErrUnsetEnvironmentVar, envDatabaseURL)
+	}
+	return core.ConnectURL(c.database)
+}
+
+func (c *config) dial(ctx context.Context) (*core.Node, error) {
+	if c.node == "" {
+		return nil, fmt.Errorf("%w: %s", core.ErrUnsetEnvironmentVar, envEthereumNode)
+	}
+	return core.Dial(ctx, c.node)
+}
+
+func (c *config) etherscanClient() (*core.EtherscanClient, error) {
+	if c.etherscan == "" {
+		return nil, fmt.Errorf("%w: %s", core.ErrUnsetEnvironmentVar, envEtherscanKey)
+	}
+	return core.NewEtherscanClient(c.etherscan), nil
+}
+
+// +--------+
+// | output |
+// +--------+
+
+// table is the output of a command: `value` is printed as JSON, or
+// `rows` under `header` as a table.
+type table struct {
+	value  any
+	header []string
+	rows   [][]string
+}
+
+func (c *config) print(w io.Writer, t table) error {
+	if c.format == formatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(t.value)
+		if err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// +------------+
+// | topicsFlag |
+// +------------+
+
+// topicsFlag collects a topic filter: each use of the flag sets the
+// next position, to a comma-separated list of accepted topics, or to
+// anything if empty.
+type topicsFlag [][]string
+
+func (f *topicsFlag) String() string {
+	positions := make([]string, len(*f))
+	for i, position := range *f {
+		positions[i] = strings.Join(position, ",")
+	}
+	return strings.Join(positions, " ")
+}
+
+func (f *topicsFlag) Set(value string) error {
+	var position []string
+	if value != "" {
+		position = strings.Split(value, ",")
+	}
+	*f = append(*f, position)
+	return nil
+}
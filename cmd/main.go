@@ -1,65 +1,139 @@
+// Command main backfills, follows and inspects the logs of Ethereum
+// contracts.  Run it without arguments for usage.
 package main
 
 import (
 	"context"
-	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/blocksignalio/core"
 )
 
-func main() {
-	const (
-		dao  = "0xBB9bc244D798123fDe783fCc1C72d3Bb8C189413"
-		weth = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
-		pepe = "0x6982508145454Ce325dDbE47a25d4ec3d2311933"
-	)
+const usage = `Usage: main <command> [flags] [arguments]
 
-	db, err := core.Open()
-	if err != nil {
-		panic(err)
-	}
-	core.BackfillLogs(context.Background(), db, "0x57a9cbED053f37EB67d6f5932b1F2f9Afbe347F3")
-	// fmt.Println(core.SelectLogs(db, "0x57a9cbED053F37EB67D6F5932B1F2F9AFBE347F3", "", 0, 0))
+Commands:
+  backfill <address...>  Store the logs of contracts up to the confirmed head.
+  follow <address>       Backfill a contract, then keep ingesting new logs.
+  logs <address...>      Print the stored logs of contracts, most recent first.
+  abi <address>          Print the ABI of a verified contract.
+  source <address>       Print the verified source of a contract.
+  creation <address...>  Print who created contracts, and in which transaction.
+  events <address>       Print the events of a contract.
 
-	if true {
-		return
-	}
+Flags common to all commands:
+  -database URL   Postgres URL, overrides DATABASE_URL
+  -node URL       Ethereum JSON-RPC URL, overrides ETHEREUM_NODE
+  -etherscan KEY  Etherscan API key, overrides ETHERSCAN_APIKEY
+  -format F       output format, "table" or "json" (default "table")
 
-	var fromBlock uint64 = 17899693
-	contract := weth
-	toBlock, logs, err := core.QueryLogs(context.TODO(), fromBlock, contract)
-	if err != nil {
-		panic(err)
-	}
-	for i, log := range logs[:3] {
-		fmt.Printf("logs[%d]:\n", i)
-		fmt.Println("\taddress:", hex.EncodeToString(log.Address[:]))
-		fmt.Println("\tblockHash:", hex.EncodeToString(log.BlockHash[:]))
-		fmt.Println("\tblockNumber:", log.BlockNumber)
-		fmt.Println("\tdata:", hex.EncodeToString(log.Data))
-		fmt.Println("\tindex:", log.Index)
-		fmt.Println("\tremoved:", log.Removed)
-		for j, topic := range log.Topics {
-			fmt.Printf("\ttopic[%d]: %s\n", j, hex.EncodeToString(topic[:]))
-		}
-		fmt.Println("\ttxHash:", hex.EncodeToString(log.TxHash[:]))
-		fmt.Println("\ttxIndex:", log.TxIndex)
+Run "main <command> -h" for the flags of a command.
+
+Exit status:
+  0  success
+  1  any other failure
+  2  bad usage
+  3  invalid address, topic, block range, page or cursor
+  4  missing database URL, node URL or API key
+  5  contract not found or not verified
+  6  rate limited by Etherscan
+  7  Etherscan API key rejected
+  8  unexpected response from Etherscan
+`
+
+// Exit statuses; see usage.
+const (
+	exitOK = iota
+	exitFailure
+	exitUsage
+	exitInvalidArgument
+	exitConfig
+	exitNotFound
+	exitRateLimited
+	exitInvalidAPIKey
+	exitBadResponse
+)
+
+// exitCode maps an error to the exit status of the command.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, core.ErrInvalidContractAddress),
+		errors.Is(err, core.ErrInvalidTopic),
+		errors.Is(err, core.ErrTooManyTopics),
+		errors.Is(err, core.ErrNegativePage),
+		errors.Is(err, core.ErrPageOutOfRange),
+		errors.Is(err, core.ErrInvalidCursor),
+		errors.Is(err, core.ErrInvalidTxHash),
+		errors.Is(err, core.ErrInvalidBlockRange):
+		return exitInvalidArgument
+	case errors.Is(err, core.ErrUnsetEnvironmentVar):
+		return exitConfig
+	case errors.Is(err, core.ErrNotFound),
+		errors.Is(err, core.ErrContractNotVerified):
+		return exitNotFound
+	case errors.Is(err, core.ErrRateLimited):
+		return exitRateLimited
+	case errors.Is(err, core.ErrInvalidAPIKey):
+		return exitInvalidAPIKey
+	case errors.Is(err, core.ErrInvalidResponse),
+		errors.Is(err, core.ErrInvalidResponseBody):
+		return exitBadResponse
+	default:
+		return exitFailure
 	}
-	fmt.Println(fromBlock, toBlock, toBlock-fromBlock, len(logs))
+}
 
-	// ch := make(chan<- types.Log)
-	// sub, err := client.SubscribeFilterLogs(context.TODO(), query, ch)
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// fmt.Println(sub)
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
 
-	// balance, err := client.BalanceAt(context.TODO(), target, big.NewInt(19984478))
-	// if err != nil {
-	// 	panic(err)
-	// }
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
 
-	// eth := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
-	// fmt.Println(new(big.Int).Div(balance, eth))
+	var cmd func(context.Context, []string, io.Writer, io.Writer) error
+	switch args[0] {
+	case "backfill":
+		cmd = cmdBackfill
+	case "follow":
+		cmd = cmdFollow
+	case "logs":
+		cmd = cmdLogs
+	case "abi":
+		cmd = cmdABI
+	case "source":
+		cmd = cmdSource
+	case "creation":
+		cmd = cmdCreation
+	case "events":
+		cmd = cmdEvents
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+
+	err := cmd(ctx, args[1:], stdout, stderr)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(stderr, "%s: %v\n", args[0], err)
+	}
+	return exitCode(err)
 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blocksignalio/core"
+)
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{flag.ErrHelp, exitOK},
+		{fmt.Errorf("%w: wrong number of arguments", errUsage), exitUsage},
+		{fmt.Errorf("wrapped: %w", core.ErrInvalidContractAddress), exitInvalidArgument},
+		{core.ErrInvalidTopic, exitInvalidArgument},
+		{core.ErrInvalidCursor, exitInvalidArgument},
+		{core.ErrPageOutOfRange, exitInvalidArgument},
+		{core.ErrInvalidBlockRange, exitInvalidArgument},
+		{fmt.Errorf("%w: %s", core.ErrUnsetEnvironmentVar, envDatabaseURL), exitConfig},
+		{core.ErrContractNotVerified, exitNotFound},
+		{core.ErrRateLimited, exitRateLimited},
+		{core.ErrInvalidAPIKey, exitInvalidAPIKey},
+		{core.ErrInvalidResponseBody, exitBadResponse},
+		{errors.New("connection refused"), exitFailure},
+	}
+	for _, test := range tests {
+		if have := exitCode(test.err); have != test.want {
+			t.Errorf("%v: have=%d want=%d", test.err, have, test.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct { //nolint:exhaustruct
+		name    string
+		args    []string
+		minArgs int
+		maxArgs int
+		want    []string
+		topics  [][]string
+		wantErr error
+	}{
+		{
+			name:    "flags anywhere",
+			args:    []string{"0x1", "-topic", "0xa,0xb", "0x2", "-topic", "", "-topic", "0xc"},
+			minArgs: 1,
+			maxArgs: -1,
+			want:    []string{"0x1", "0x2"},
+			topics: [][]string{
+				{"0xa", "0xb"},
+				nil,
+				{"0xc"},
+			},
+		},
+		{
+			name:    "too few",
+			args:    []string{},
+			minArgs: 1,
+			maxArgs: -1,
+			wantErr: errUsage,
+		},
+		{
+			name:    "too many",
+			args:    []string{"0x1", "0x2"},
+			minArgs: 1,
+			maxArgs: 1,
+			wantErr: errUsage,
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"-nope", "0x1"},
+			minArgs: 1,
+			maxArgs: 1,
+			wantErr: errUsage,
+		},
+		{
+			name:    "unknown format",
+			args:    []string{"-format", "xml", "0x1"},
+			minArgs: 1,
+			maxArgs: 1,
+			wantErr: errUsage,
+		},
+		{
+			name:    "help",
+			args:    []string{"-h"},
+			minArgs: 1,
+			maxArgs: 1,
+			wantErr: flag.ErrHelp,
+		},
+	}
+	for _, test := range tests {
+		var (
+			c      config
+			topics topicsFlag
+		)
+		fs := newFlagSet("test", "<address...>", &c, io.Discard)
+		fs.Var(&topics, "topic", "")
+		have, err := parse(fs, &c, test.args, test.minArgs, test.maxArgs)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: error: have=%v want=%v", test.name, err, test.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(test.want, have); diff != "" {
+			t.Errorf("%s: arguments (-want +have):\n%s", test.name, diff)
+		}
+		if diff := cmp.Diff(test.topics, [][]string(topics)); diff != "" {
+			t.Errorf("%s: topics (-want +have):\n%s", test.name, diff)
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	t.Parallel()
+
+	const (
+		weth     = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+		transfer = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	)
+
+	// None of these gets to connect to anything.
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{}, exitUsage},
+		{[]string{"nope"}, exitUsage},
+		{[]string{"help"}, exitOK},
+		{[]string{"logs", "-h"}, exitOK},
+		{[]string{"logs"}, exitUsage},
+		{[]string{"logs", "nonsense"}, exitInvalidArgument},
+		{[]string{"logs", "-topic", "nonsense", weth}, exitInvalidArgument},
+		{[]string{"logs", "-topic0", transfer, weth}, exitUsage},
+		{[]string{"logs", "-from-block", "9", "-to-block", "8", weth}, exitInvalidArgument},
+		{[]string{"logs", "-cursor", "", "-page", "1", weth}, exitUsage},
+		{[]string{"logs", "-database", "", weth}, exitConfig},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if have := run(context.Background(), test.args, &stdout, &stderr); have != test.want {
+			t.Errorf("%q: have=%d want=%d\n%s", test.args, have, test.want, stderr.String())
+		}
+	}
+}
+
+func TestParseLogs(t *testing.T) {
+	t.Parallel()
+
+	const (
+		weth     = "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"
+		transfer = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+		approval = "0x8c5be1e5ebec7d5bd14b71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"
+		owner    = "0x000000000000000000000000000000000000000000000000000000000000dead"
+	)
+
+	tests := []struct { //nolint:exhaustruct
+		name     string
+		args     []string
+		want     core.LogFilter
+		count    bool
+		byCursor bool
+		wantErr  error
+	}{
+		{
+			name: "topics by position",
+			args: []string{"-topic", transfer + "," + approval, "-topic", "", "-topic", owner, weth},
+			want: core.LogFilter{ //nolint:exhaustruct
+				Addresses: []string{weth},
+				Topics:    [][]string{{transfer, approval}, nil, {owner}},
+				PageSize:  100,
+			},
+		},
+		{
+			name: "oldest first",
+			args: []string{"-asc", "-page", "2", "-page-size", "10", "-from-block", "5", weth},
+			want: core.LogFilter{ //nolint:exhaustruct
+				Addresses: []string{weth},
+				FromBlock: 5,
+				Order:     core.OldestFirst,
+				Page:      2,
+				PageSize:  10,
+			},
+		},
+		{
+			name: "count",
+			args: []string{"-count", weth},
+			want: core.LogFilter{ //nolint:exhaustruct
+				Addresses: []string{weth},
+				PageSize:  100,
+			},
+			count: true,
+		},
+		{
+			name: "first cursor page",
+			args: []string{"-cursor", "", weth},
+			want: core.LogFilter{ //nolint:exhaustruct
+				Addresses: []string{weth},
+				PageSize:  100,
+			},
+			byCursor: true,
+		},
+		{
+			name:    "too many topics",
+			args:    []string{"-topic", "", "-topic", "", "-topic", "", "-topic", "", "-topic", "", weth},
+			wantErr: core.ErrTooManyTopics,
+		},
+		{
+			name:    "invalid topic",
+			args:    []string{"-topic", "0x01", weth},
+			wantErr: core.ErrInvalidTopic,
+		},
+		{
+			name:    "cursor and page",
+			args:    []string{"-cursor", "", "-page", "1", weth},
+			wantErr: errUsage,
+		},
+	}
+	for _, test := range tests {
+		have, err := parseLogs(test.args, io.Discard)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: error: have=%v want=%v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if diff := cmp.Diff(test.want, have.filter); diff != "" {
+			t.Errorf("%s: filter (-want +have):\n%s", test.name, diff)
+		}
+		if have.count != test.count {
+			t.Errorf("%s: count: have=%v want=%v", test.name, have.count, test.count)
+		}
+		if have.byCursor != test.byCursor {
+			t.Errorf("%s: by cursor: have=%v want=%v", test.name, have.byCursor, test.byCursor)
+		}
+	}
+}
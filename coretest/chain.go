@@ -0,0 +1,408 @@
+// Package coretest provides in-process stand-ins for an Ethereum node
+// and for the Etherscan API, so that code built on package core can be
+// tested offline.
+package coretest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/blocksignalio/core"
+)
+
+// https://github.com/ethereum/EIPs/blob/master/EIPS/eip-1474.md
+const codeLimitExceeded = -32005
+
+// Chain is an in-memory chain served over JSON-RPC, standing in for an
+// Ethereum node.  It answers eth_chainId, eth_blockNumber,
+// eth_getBlockByNumber, eth_getTransactionReceipt and eth_getLogs.
+// Its methods are safe for concurrent use, including while serving.
+type Chain struct {
+	mu         sync.Mutex
+	chainID    uint64
+	head       uint64
+	logs       []types.Log
+	receipts   map[common.Hash]uint64
+	reorgs     []uint64
+	maxRange   uint64
+	maxResults int
+	queries    [][2]uint64
+}
+
+func NewChain(chainID, head uint64) *Chain {
+	return &Chain{
+		mu:         sync.Mutex{},
+		chainID:    chainID,
+		head:       head,
+		logs:       nil,
+		receipts:   make(map[common.Hash]uint64),
+		reorgs:     nil,
+		maxRange:   0,
+		maxResults: 0,
+		queries:    nil,
+	}
+}
+
+// SetHead moves the head of the chain.
+func (c *Chain) SetHead(head uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.head = head
+}
+
+func (c *Chain) Head() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head
+}
+
+// SetMaxRange makes eth_getLogs reject ranges of more than `n` blocks
+// with a -32005 error whose data holds the last block that may be
+// queried.  Zero removes the limit.
+func (c *Chain) SetMaxRange(n uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxRange = n
+}
+
+// SetMaxResults makes eth_getLogs reject queries matching more than `n`
+// logs with a -32005 error suggesting a narrower range, as Infura does.
+// Zero removes the limit.
+func (c *Chain) SetMaxResults(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxResults = n
+}
+
+// AddLogs adds logs to the chain.  Their block hashes are set when they
+// are served.
+func (c *Chain) AddLogs(xs ...types.Log) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logs = append(c.logs, xs...)
+	sortLogs(c.logs)
+}
+
+// AddTransaction records that `tx` is included in `block`, for
+// eth_getTransactionReceipt.
+func (c *Chain) AddTransaction(tx string, block uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.receipts[common.HexToHash(tx)] = block
+}
+
+// Reorg replaces the blocks from `from` on: their hashes change, and
+// their logs are replaced by `xs`.
+func (c *Chain) Reorg(from uint64, xs ...types.Log) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := c.logs[:0]
+	for _, x := range c.logs {
+		if x.BlockNumber < from {
+			kept = append(kept, x)
+		}
+	}
+	c.logs = append(kept, xs...)
+	sortLogs(c.logs)
+	c.reorgs = append(c.reorgs, from)
+}
+
+// Queries returns the ranges of blocks, inclusive, of the eth_getLogs
+// requests received so far, rejected or not.
+func (c *Chain) Queries() [][2]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.queries)
+}
+
+// BlockHash returns the hash of the canonical block at `number`.
+func (c *Chain) BlockHash(number uint64) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blockHash(number).Hex()
+}
+
+func (c *Chain) blockHash(number uint64) common.Hash {
+	var epoch uint64
+	for _, from := range c.reorgs {
+		if from <= number {
+			epoch++
+		}
+	}
+	b := make([]byte, 0, 24)
+	b = binary.BigEndian.AppendUint64(b, c.chainID)
+	b = binary.BigEndian.AppendUint64(b, number)
+	b = binary.BigEndian.AppendUint64(b, epoch)
+	return crypto.Keccak256Hash(b)
+}
+
+// Server returns a JSON-RPC server for the chain.
+func (c *Chain) Server() *rpc.Server {
+	server := rpc.NewServer()
+	err := server.RegisterName("eth", &ethService{chain: c})
+	if err != nil {
+		panic(err)
+	}
+	return server
+}
+
+// Node returns a core.Node connected in-process to the chain, closed
+// when the test ends.
+func (c *Chain) Node(t testing.TB) *core.Node {
+	t.Helper()
+	server := c.Server()
+	node := core.NewNode(rpc.DialInProc(server))
+	t.Cleanup(func() {
+		node.Close()
+		server.Stop()
+	})
+	return node
+}
+
+// URL serves the chain over HTTP until the test ends and returns its
+// URL, e.g. to set ETHEREUM_NODE.
+func (c *Chain) URL(t testing.TB) string {
+	t.Helper()
+	server := c.Server()
+	h := httptest.NewServer(server)
+	t.Cleanup(func() {
+		h.Close()
+		server.Stop()
+	})
+	return h.URL
+}
+
+// MakeLog returns a log of `contract` at the given position.  Its
+// transaction hash is derived from the position.
+func MakeLog(contract string, block uint64, index uint, topics ...string) types.Log {
+	hashes := make([]common.Hash, len(topics))
+	for i, topic := range topics {
+		hashes[i] = common.HexToHash(topic)
+	}
+	b := make([]byte, 0, 16)
+	b = binary.BigEndian.AppendUint64(b, block)
+	b = binary.BigEndian.AppendUint64(b, uint64(index))
+	return types.Log{ //nolint:exhaustruct
+		Address:     common.HexToAddress(contract),
+		Topics:      hashes,
+		Data:        []byte{},
+		BlockNumber: block,
+		TxHash:      crypto.Keccak256Hash(b),
+		TxIndex:     index,
+		Index:       index,
+	}
+}
+
+func sortLogs(xs []types.Log) {
+	sort.SliceStable(xs, func(i, j int) bool {
+		if xs[i].BlockNumber != xs[j].BlockNumber {
+			return xs[i].BlockNumber < xs[j].BlockNumber
+		}
+		return xs[i].Index < xs[j].Index
+	})
+}
+
+// +------------+
+// | ethService |
+// +------------+
+
+// rangeError is a -32005 error, as returned by nodes rejecting a range.
+type rangeError struct {
+	message  string
+	from, to uint64
+}
+
+func (e *rangeError) Error() string {
+	return e.message
+}
+
+func (e *rangeError) ErrorCode() int {
+	return codeLimitExceeded
+}
+
+func (e *rangeError) ErrorData() any {
+	return map[string]string{
+		"from": hexutil.EncodeUint64(e.from),
+		"to":   hexutil.EncodeUint64(e.to),
+	}
+}
+
+// blockArg is a block number or tag.  Tags other than "earliest" mean
+// the head.
+type blockArg struct {
+	number uint64
+	head   bool
+}
+
+func (b *blockArg) UnmarshalJSON(input []byte) error {
+	var s string
+	err := json.Unmarshal(input, &s)
+	if err != nil {
+		return fmt.Errorf("block: %w", err)
+	}
+	switch s {
+	case "earliest":
+		b.number = 0
+	case "latest", "pending", "safe", "finalized":
+		b.head = true
+	default:
+		b.number, err = hexutil.DecodeUint64(s)
+		if err != nil {
+			return fmt.Errorf("block: %w", err)
+		}
+	}
+	return nil
+}
+
+func (b *blockArg) resolve(head, otherwise uint64) uint64 {
+	switch {
+	case b == nil:
+		return otherwise
+	case b.head:
+		return head
+	default:
+		return b.number
+	}
+}
+
+type filterArgs struct {
+	FromBlock *blockArg        `json:"fromBlock"`
+	ToBlock   *blockArg        `json:"toBlock"`
+	Addresses []common.Address `json:"address"`
+	Topics    [][]common.Hash  `json:"topics"`
+}
+
+func (f filterArgs) match(x types.Log) bool {
+	if len(f.Addresses) > 0 && !contains(f.Addresses, x.Address) {
+		return false
+	}
+	if len(f.Topics) > len(x.Topics) {
+		return false
+	}
+	for i, position := range f.Topics {
+		if len(position) > 0 && !contains(position, x.Topics[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains[T comparable](xs []T, y T) bool {
+	for _, x := range xs {
+		if x == y {
+			return true
+		}
+	}
+	return false
+}
+
+type ethService struct {
+	chain *Chain
+}
+
+func (s *ethService) ChainId() *hexutil.Big { //nolint:revive,stylecheck
+	c := s.chain
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return (*hexutil.Big)(new(big.Int).SetUint64(c.chainID))
+}
+
+func (s *ethService) BlockNumber() hexutil.Uint64 {
+	return hexutil.Uint64(s.chain.Head())
+}
+
+func (s *ethService) GetBlockByNumber(number blockArg, _ bool) map[string]any {
+	c := s.chain
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := number.resolve(c.head, c.head)
+	if n > c.head {
+		return nil
+	}
+	hash := c.blockHash(n)
+	parent := common.Hash{}
+	if n > 0 {
+		parent = c.blockHash(n - 1)
+	}
+	return map[string]any{
+		"number":     hexutil.Uint64(n),
+		"hash":       hash,
+		"parentHash": parent,
+	}
+}
+
+func (s *ethService) GetTransactionReceipt(tx common.Hash) *types.Receipt {
+	c := s.chain
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	block, ok := c.receipts[tx]
+	if !ok || block > c.head {
+		return nil
+	}
+	return &types.Receipt{ //nolint:exhaustruct
+		Status:      types.ReceiptStatusSuccessful,
+		Logs:        []*types.Log{},
+		TxHash:      tx,
+		BlockHash:   c.blockHash(block),
+		BlockNumber: new(big.Int).SetUint64(block),
+	}
+}
+
+func (s *ethService) GetLogs(args filterArgs) ([]types.Log, error) {
+	c := s.chain
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from := args.FromBlock.resolve(c.head, c.head)
+	to := min(args.ToBlock.resolve(c.head, c.head), c.head)
+	if from > to {
+		return nil, errors.New("invalid block range params")
+	}
+	c.queries = append(c.queries, [2]uint64{from, to})
+	if c.maxRange != 0 && to-from+1 > c.maxRange {
+		return nil, &rangeError{
+			message: fmt.Sprintf("exceed maximum block range: %d", c.maxRange),
+			from:    from,
+			to:      from + c.maxRange - 1,
+		}
+	}
+
+	xs := make([]types.Log, 0)
+	for _, x := range c.logs {
+		if x.BlockNumber < from || x.BlockNumber > to || !args.match(x) {
+			continue
+		}
+		x.BlockHash = c.blockHash(x.BlockNumber)
+		xs = append(xs, x)
+	}
+
+	if c.maxResults != 0 && len(xs) > c.maxResults {
+		// Suggest the blocks before the first one that overflows.
+		last := max(from, xs[c.maxResults].BlockNumber-1)
+		return nil, &rangeError{
+			message: fmt.Sprintf(
+				"query returned more than %d results. Try with this block range [%s, %s].",
+				c.maxResults,
+				hexutil.EncodeUint64(from),
+				hexutil.EncodeUint64(last),
+			),
+			from: from,
+			to:   last,
+		}
+	}
+	return xs, nil
+}
@@ -0,0 +1,105 @@
+package coretest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/blocksignalio/core"
+)
+
+const (
+	envDatabaseURL = "DATABASE_URL"
+	// envRequireDB makes tests needing a database fail, rather than
+	// skip, when DATABASE_URL is not set, so that CI cannot pass
+	// without running them.
+	envRequireDB = "CORETEST_REQUIRE_DB"
+)
+
+// DB connects to the Postgres database at DATABASE_URL, in a schema of
+// its own that is migrated, and dropped when the test ends, so that
+// tests can run in parallel.  The test is skipped if DATABASE_URL is
+// not set, or fails if CORETEST_REQUIRE_DB is set too.
+func DB(t testing.TB) *gorm.DB {
+	t.Helper()
+	base, schema := EmptyDB(t)
+	db, err := core.OpenURL(SchemaURL(base, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, db)
+	return db
+}
+
+// EmptyDB is like DB, but leaves the schema empty, e.g. to test
+// migrations, and returns the URL of the database and the name of the
+// schema instead of a connection.
+func EmptyDB(t testing.TB) (string, string) {
+	t.Helper()
+	base := os.Getenv(envDatabaseURL)
+	if base == "" {
+		if os.Getenv(envRequireDB) != "" {
+			t.Fatalf("%s not set, but %s is", envDatabaseURL, envRequireDB)
+		}
+		t.Skipf("%s not set", envDatabaseURL)
+	}
+
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "coretest_" + hex.EncodeToString(b)
+
+	admin, err := gorm.Open(postgres.Open(base), &gorm.Config{ //nolint:exhaustruct
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, admin)
+	err = admin.Exec("CREATE SCHEMA " + schema).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		err := admin.Exec("DROP SCHEMA " + schema + " CASCADE").Error
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	return base, schema
+}
+
+// SchemaURL returns the URL of the database at `base`, with `schema` as
+// search path.
+func SchemaURL(base, schema string) string {
+	if !strings.Contains(base, "://") {
+		// Keyword/value connection string.
+		return base + " search_path=" + schema
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func closeOnCleanup(t testing.TB, db *gorm.DB) {
+	t.Helper()
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+}
@@ -0,0 +1,170 @@
+package coretest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blocksignalio/core"
+)
+
+const (
+	// APIKey is the only key the fake Etherscan accepts.
+	APIKey = "TESTKEY"
+
+	// WETH is the address of Wrapped Ether on mainnet, whose ABI and
+	// creation are served by default.
+	WETH = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+	// WETHCreator, WETHCreationTx and WETHCreationBlock describe the
+	// deployment of WETH.
+	WETHCreator       = "0x4f26ffbe5f04ed43630fdc30a87638d53d0b0876"
+	WETHCreationTx    = "0xb95343413e459a0f97461812111254163ae53467855c0d73e0f1e7c5b8442fa3"
+	WETHCreationBlock = 4719568
+)
+
+// WETHABI is the ABI of WETH, as served by Etherscan.
+//
+//go:embed fixtures/weth.abi.json
+var WETHABI string
+
+// Etherscan stands in for the Etherscan API, serving the ABIs and
+// creations it was given: getabi, getsourcecode and
+// getcontractcreation.  Other contracts are reported as not verified,
+// or not found.  It is safe for concurrent use.
+type Etherscan struct {
+	mu        sync.Mutex
+	abis      map[string]string
+	creations map[string]core.ContractCreation
+	failures  map[string]string
+	calls     int
+}
+
+// NewEtherscan returns a fake Etherscan that knows WETH.
+func NewEtherscan() *Etherscan {
+	e := &Etherscan{
+		mu:        sync.Mutex{},
+		abis:      make(map[string]string),
+		creations: make(map[string]core.ContractCreation),
+		failures:  make(map[string]string),
+		calls:     0,
+	}
+	e.AddABI(WETH, WETHABI)
+	e.AddCreation(WETH, WETHCreator, WETHCreationTx)
+	return e
+}
+
+// AddABI makes `contract` verified with the given JSON ABI.
+func (e *Etherscan) AddABI(contract, abi string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.abis[strings.ToLower(contract)] = strings.TrimSpace(abi)
+}
+
+// AddCreation records the deployment of `contract`.
+func (e *Etherscan) AddCreation(contract, creator, tx string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.creations[strings.ToLower(contract)] = core.ContractCreation{
+		ContractAddress: strings.ToLower(contract),
+		ContractCreator: creator,
+		TxHash:          tx,
+	}
+}
+
+// Fail makes the requests of `action`, e.g. "getabi", fail with
+// `result`, the way Etherscan reports errors, e.g. "Max rate limit
+// reached".  An empty `result` makes them succeed again.
+func (e *Etherscan) Fail(action, result string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if result == "" {
+		delete(e.failures, action)
+	} else {
+		e.failures[action] = result
+	}
+}
+
+// Calls returns the number of requests served.
+func (e *Etherscan) Calls() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calls
+}
+
+// Client serves the fake until the test ends and returns a client for
+// it, without rate limit.
+func (e *Etherscan) Client(t testing.TB) *core.EtherscanClient {
+	t.Helper()
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+
+	client := core.NewEtherscanClient(APIKey)
+	client.BaseURL = server.URL
+	client.RateLimit = 0
+	client.RetryBackoff = time.Millisecond
+	return client
+}
+
+func (e *Etherscan) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls++
+
+	query := r.URL.Query()
+	var body map[string]any
+	switch {
+	case query.Get("apikey") != APIKey:
+		body = failure("Invalid API Key")
+	case e.failures[query.Get("action")] != "":
+		body = failure(e.failures[query.Get("action")])
+	case query.Get("action") == "getabi":
+		abi, ok := e.abis[strings.ToLower(query.Get("address"))]
+		if !ok {
+			body = failure("Contract source code not verified")
+		} else {
+			body = success(abi)
+		}
+	case query.Get("action") == "getsourcecode":
+		abi, ok := e.abis[strings.ToLower(query.Get("address"))]
+		if !ok {
+			abi = "Contract source code not verified"
+		}
+		body = success([]core.ContractSource{{ABI: abi}}) //nolint:exhaustruct
+	case query.Get("action") == "getcontractcreation":
+		var xs []core.ContractCreation
+		for _, contract := range strings.Split(query.Get("contractaddresses"), ",") {
+			x, ok := e.creations[strings.ToLower(contract)]
+			if ok {
+				xs = append(xs, x)
+			}
+		}
+		if len(xs) == 0 {
+			body = failure("No data found")
+		} else {
+			body = success(xs)
+		}
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+
+	content, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(content)
+}
+
+func success(result any) map[string]any {
+	return map[string]any{"status": "1", "message": "OK", "result": result}
+}
+
+func failure(result string) map[string]any {
+	return map[string]any{"status": "0", "message": "NOTOK", "result": result}
+}
@@ -1,8 +1,11 @@
 package core
 
 import (
+	"encoding/base64"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 
 	"gorm.io/driver/postgres"
@@ -11,11 +14,31 @@ import (
 
 const envDatabaseURL = "DATABASE_URL"
 
+// Open connects to the database at DATABASE_URL; see OpenURL.
 func Open() (*gorm.DB, error) {
 	url := os.Getenv(envDatabaseURL)
 	if url == "" {
 		return nil, fmt.Errorf("%w: %s", ErrUnsetEnvironmentVar, envDatabaseURL)
 	}
+	return OpenURL(url)
+}
+
+// OpenURL connects to the Postgres database at `url` and migrates its
+// schema; see Migrate.
+func OpenURL(url string) (*gorm.DB, error) {
+	db, err := ConnectURL(url)
+	if err != nil {
+		return nil, err
+	}
+	err = Migrate(db)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return db, nil
+}
+
+// ConnectURL is like OpenURL, but leaves the schema alone, for readers.
+func ConnectURL(url string) (*gorm.DB, error) {
 	db, err := gorm.Open(
 		postgres.Open(url),
 		&gorm.Config{ //nolint:exhaustruct
@@ -25,17 +48,14 @@ func Open() (*gorm.DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("open: %w", err)
 	}
-	err = db.AutoMigrate(&Log{}) //nolint:exhaustruct
-	if err != nil {
-		return nil, fmt.Errorf("migrate: %w", err)
-	}
 	return db, nil
 }
 
 func Paginate(query *gorm.DB, page int, pageSize int) (*gorm.DB, error) {
 	// Validate input.
-	if page < 0 {
-		return nil, ErrNegativePage
+	err := validatePage(page, pageSize)
+	if err != nil {
+		return nil, err
 	}
 
 	// A zero or negative `pageSize` is a special case where the
@@ -51,32 +71,123 @@ func Paginate(query *gorm.DB, page int, pageSize int) (*gorm.DB, error) {
 	return query.Limit(pageSize).Offset(offset), nil
 }
 
+// validatePage checks that the offset of the page fits in an int.
+func validatePage(page, pageSize int) error {
+	if page < 0 {
+		return ErrNegativePage
+	}
+	if pageSize > 0 && page > math.MaxInt/pageSize {
+		return fmt.Errorf("%w: %d", ErrPageOutOfRange, page)
+	}
+	return nil
+}
+
 func SelectLogs(db *gorm.DB, contract string, topic string, page, pageSize int) ([]Log, error) {
-	// Validate input.
+	return SelectLogsRange(db, contract, topic, 0, 0, page, pageSize)
+}
+
+// SelectLogsRange is like SelectLogs, but only returns the logs of
+// blocks [fromBlock, toBlock].  A zero toBlock means no upper bound.
+func SelectLogsRange(db *gorm.DB, contract, topic string, fromBlock, toBlock uint64, page, pageSize int) ([]Log, error) {
 	if !ValidateAddress(contract) {
 		return nil, makeErrorHex(ErrInvalidContractAddress, contract)
 	}
-	if topic != "" && !ValidateTopic(topic) {
-		return nil, makeErrorHex(ErrInvalidTopic, topic)
+	filter := LogFilter{
+		Addresses: []string{contract},
+		Topics:    nil,
+		FromBlock: fromBlock,
+		ToBlock:   toBlock,
+		TxHash:    "",
+		Order:     NewestFirst,
+		Page:      page,
+		PageSize:  pageSize,
 	}
-
-	// Prepare query.
-	query := db.Select("*").Where("address = ?", strings.ToLower(contract))
 	if topic != "" {
-		query = query.Where("topic0 = ?", strings.ToLower(topic))
+		filter.Topics = [][]string{{topic}}
 	}
-	query = query.Order("block_number desc, index desc")
-	query, err := Paginate(query, page, pageSize)
-	if err != nil {
-		return nil, err
+	return filter.Find(db)
+}
+
+// +--------+
+// | Cursor |
+// +--------+
+
+// LogCursor is a position in the logs of a contract, in the order of
+// SelectLogs, along with the direction to go from there.  Clients see
+// it as an opaque string.
+type LogCursor struct {
+	BlockNumber uint64
+	Index       uint
+	// Backward goes towards more recent logs.
+	Backward bool
+}
+
+func (c LogCursor) String() string {
+	direction := 'n'
+	if c.Backward {
+		direction = 'p'
 	}
+	s := fmt.Sprintf("%c%d.%d", direction, c.BlockNumber, c.Index)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
 
-	// Execute query.
-	var xs []Log
-	result := query.Find(&xs)
-	if result.Error != nil {
-		return xs, fmt.Errorf("find: %w", result.Error)
+// ParseLogCursor reads a cursor returned by LogCursor.String.
+func ParseLogCursor(s string) (LogCursor, error) {
+	var c LogCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return c, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+	c.Backward = b[0] == 'p'
+	if b[0] != 'n' && b[0] != 'p' {
+		return c, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+	block, index, ok := strings.Cut(string(b[1:]), ".")
+	if !ok {
+		return c, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
 	}
+	c.BlockNumber, err = strconv.ParseUint(block, 10, 64)
+	if err != nil {
+		return c, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+	i, err := strconv.ParseUint(index, 10, 32)
+	if err != nil {
+		return c, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+	c.Index = uint(i)
+	return c, nil
+}
+
+// CursorPage is a page of logs, most recent first, with the cursors of
+// the pages around it.
+type CursorPage struct {
+	Logs []Log `json:"logs"`
+	// Next continues with older logs, Prev with more recent ones.  They
+	// are empty when there are none, except that an empty page past
+	// either end leads back to the page at that end.
+	Next string `json:"next"`
+	Prev string `json:"prev"`
+}
 
-	return xs, nil
+// SelectLogsAfter is like SelectLogs, but pages through the logs with
+// cursors rather than offsets; see LogFilter.FindAfter.
+func SelectLogsAfter(db *gorm.DB, contract, topic, cursor string, limit int) (CursorPage, error) {
+	var empty CursorPage
+	if !ValidateAddress(contract) {
+		return empty, makeErrorHex(ErrInvalidContractAddress, contract)
+	}
+	filter := LogFilter{
+		Addresses: []string{contract},
+		Topics:    nil,
+		FromBlock: 0,
+		ToBlock:   0,
+		TxHash:    "",
+		Order:     NewestFirst,
+		Page:      0,
+		PageSize:  limit,
+	}
+	if topic != "" {
+		filter.Topics = [][]string{{topic}}
+	}
+	return filter.FindAfter(db, cursor)
 }
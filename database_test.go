@@ -0,0 +1,106 @@
+package core_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/coretest"
+)
+
+func TestLogCursor(t *testing.T) {
+	t.Parallel()
+
+	for _, want := range []core.LogCursor{
+		{BlockNumber: 0, Index: 0, Backward: false},
+		{BlockNumber: 4719568, Index: 12, Backward: false},
+		{BlockNumber: 19000000, Index: 300, Backward: true},
+	} {
+		have, err := core.ParseLogCursor(want.String())
+		if err != nil {
+			t.Fatalf("%s: %v", want, err)
+		}
+		if have != want {
+			t.Errorf("%s: have=%+v want=%+v", want, have, want)
+		}
+	}
+
+	for _, s := range []string{"", "!", "eDEuMg", "bjE", "bjEuLTE", "bmEuMQ"} {
+		_, err := core.ParseLogCursor(s)
+		if !errors.Is(err, core.ErrInvalidCursor) {
+			t.Errorf("%q: have=%v want=%v", s, err, core.ErrInvalidCursor)
+		}
+	}
+}
+
+func TestFindAfter(t *testing.T) {
+	t.Parallel()
+
+	db := coretest.DB(t)
+	for block := uint64(1); block <= 5; block++ {
+		x := core.FromGethLog(transfer(block, 0))
+		err := db.Create(&x).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	filter := core.LogFilter{Addresses: []string{coretest.WETH}, PageSize: 2} //nolint:exhaustruct
+	find := func(cursor string) core.CursorPage {
+		t.Helper()
+		page, err := filter.FindAfter(db, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+	positions := func(page core.CursorPage) []string {
+		xs := make([]string, len(page.Logs))
+		for i, x := range page.Logs {
+			xs[i] = position(x.BlockNumber, x.Index)
+		}
+		return xs
+	}
+	at := func(block uint64, backward bool) string {
+		return core.LogCursor{BlockNumber: coretest.WETHCreationBlock + block, Index: 0, Backward: backward}.String()
+	}
+
+	tests := []struct {
+		name     string
+		cursor   string
+		want     []string
+		next     bool
+		prev     bool
+		nextWant []string
+		prevWant []string
+	}{
+		{"first", "", []string{"5/0", "4/0"}, true, false, []string{"3/0", "2/0"}, nil},
+		{"older", at(4, false), []string{"3/0", "2/0"}, true, true, []string{"1/0"}, []string{"5/0", "4/0"}},
+		{"oldest", at(2, false), []string{"1/0"}, false, true, nil, []string{"3/0", "2/0"}},
+		{"newer", at(2, true), []string{"4/0", "3/0"}, true, true, []string{"2/0", "1/0"}, []string{"5/0"}},
+		// Past either end, the way back is the page at that end.
+		{"past oldest", at(1, false), []string{}, false, true, nil, []string{"2/0", "1/0"}},
+		{"past newest", at(5, true), []string{}, true, false, []string{"5/0", "4/0"}, nil},
+	}
+	for _, test := range tests {
+		page := find(test.cursor)
+		if diff := cmp.Diff(test.want, positions(page)); diff != "" {
+			t.Errorf("%s: logs (-want +have):\n%s", test.name, diff)
+		}
+		if have := page.Next != ""; have != test.next {
+			t.Errorf("%s: next: have=%t want=%t", test.name, have, test.next)
+		} else if have {
+			if diff := cmp.Diff(test.nextWant, positions(find(page.Next))); diff != "" {
+				t.Errorf("%s: next logs (-want +have):\n%s", test.name, diff)
+			}
+		}
+		if have := page.Prev != ""; have != test.prev {
+			t.Errorf("%s: prev: have=%t want=%t", test.name, have, test.prev)
+		} else if have {
+			if diff := cmp.Diff(test.prevWant, positions(find(page.Prev))); diff != "" {
+				t.Errorf("%s: prev logs (-want +have):\n%s", test.name, diff)
+			}
+		}
+	}
+}
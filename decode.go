@@ -0,0 +1,264 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// +------------+
+// | DecodedLog |
+// +------------+
+
+// DecodedArg is a single argument of a decoded event.
+type DecodedArg struct {
+	Name    string
+	Type    string
+	Indexed bool
+	// Value holds the Go value produced by go-ethereum's abi package,
+	// e.g. common.Address or *big.Int.  Indexed arguments of dynamic
+	// type (strings, bytes, arrays, tuples) only have their keccak256
+	// hash in the topics, so their value is that common.Hash.
+	Value any
+}
+
+// DecodedLog is a Log matched against the event that emitted it.
+type DecodedLog struct {
+	Log       Log
+	Name      string
+	Signature string
+	// Arguments in the order they are declared in the event.
+	Args []DecodedArg
+}
+
+func (o DecodedLog) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "DecodedLog:\n")
+	fmt.Fprintf(&b, "\tName      : %s\n", o.Name)
+	fmt.Fprintf(&b, "\tSignature : %s\n", o.Signature)
+	for _, arg := range o.Args {
+		fmt.Fprintf(&b, "\t%-9s : %v\n", arg.Name, arg.Value)
+	}
+	return b.String()
+}
+
+// MarshalArgs encodes the arguments as a JSON object keyed by name.
+// Big integers are encoded as decimal strings, and byte strings,
+// addresses and hashes as lowercase hex.
+func (o DecodedLog) MarshalArgs() ([]byte, error) {
+	args := make(map[string]any, len(o.Args))
+	for _, arg := range o.Args {
+		args[arg.Name] = jsonValue(reflect.ValueOf(arg.Value))
+	}
+	content, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+	return content, nil
+}
+
+// StandardEvents returns the events defined in standard.go, keyed by
+// name, for use with DecodeLog when the contract ABI is not available.
+func StandardEvents() map[string]abi.Event {
+	return map[string]abi.Event{
+		Approval.Name: Approval,
+		Transfer.Name: Transfer,
+	}
+}
+
+// +---------+
+// | Private |
+// +---------+
+
+func logTopics(log Log) ([]common.Hash, error) {
+	raw := []string{log.Topic0, log.Topic1, log.Topic2, log.Topic3}
+	topics := make([]common.Hash, 0, len(raw))
+	for _, topic := range raw {
+		if topic == "" {
+			break
+		}
+		if !ValidateTopic(topic) {
+			return nil, makeErrorHex(ErrInvalidTopic, topic)
+		}
+		topics = append(topics, common.HexToHash(topic))
+	}
+	return topics, nil
+}
+
+func findEvent(events map[string]abi.Event, id common.Hash) (abi.Event, bool) {
+	for _, event := range events {
+		if !event.Anonymous && event.ID == id {
+			return event, true
+		}
+	}
+	var empty abi.Event
+	return empty, false
+}
+
+func decodeTopic(arg abi.Argument, topic common.Hash) (any, error) {
+	switch arg.Type.T { //nolint:exhaustive
+	case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return topic, nil
+	default:
+		const key = "value"
+		out := make(map[string]any, 1)
+		field := abi.Argument{Name: key, Type: arg.Type, Indexed: true}
+		err := abi.ParseTopicsIntoMap(out, abi.Arguments{field}, []common.Hash{topic})
+		if err != nil {
+			return nil, fmt.Errorf("parse topic: %w", err)
+		}
+		return out[key], nil
+	}
+}
+
+//nolint:exhaustive
+func jsonValue(v reflect.Value) any {
+	if !v.IsValid() {
+		return nil
+	}
+	switch x := v.Interface().(type) {
+	case *big.Int:
+		if x == nil {
+			return nil
+		}
+		return x.String()
+	case common.Address:
+		return prepareHex(x.Hex())
+	case common.Hash:
+		return prepareHex(x.Hex())
+	case []byte:
+		return hexutil.Encode(x)
+	}
+	switch v.Kind() {
+	case reflect.Array:
+		// Fixed-size byte arrays, e.g. bytes32.
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return hexutil.Encode(b)
+		}
+		fallthrough
+	case reflect.Slice:
+		xs := make([]any, v.Len())
+		for i := range xs {
+			xs[i] = jsonValue(v.Index(i))
+		}
+		return xs
+	case reflect.Struct:
+		// Tuples are unpacked into anonymous structs whose json tags
+		// hold the original field names.
+		m := make(map[string]any, v.NumField())
+		for i := range v.NumField() {
+			name := v.Type().Field(i).Tag.Get("json")
+			if name == "" {
+				name = v.Type().Field(i).Name
+			}
+			m[name] = jsonValue(v.Field(i))
+		}
+		return m
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return jsonValue(v.Elem())
+	default:
+		return v.Interface()
+	}
+}
+
+func argumentName(arg abi.Argument, i int) string {
+	if arg.Name == "" {
+		return fmt.Sprintf("arg%d", i)
+	}
+	return arg.Name
+}
+
+// +--------+
+// | Public |
+// +--------+
+
+// DecodeLog matches the log's Topic0 against the IDs of `events` and
+// unpacks its topics and data into named values.  It fails with
+// ErrUnknownEvent if no event matches and with ErrUndecodableLog if the
+// log does not fit the matching event, e.g. an ERC-721 Transfer decoded
+// with the ERC-20 definition.
+func DecodeLog(log Log, events map[string]abi.Event) (decoded DecodedLog, err error) {
+	// The abi package panics on some malformed inputs.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrUndecodableLog, r)
+		}
+	}()
+
+	decoded.Log = log
+
+	topics, err := logTopics(log)
+	if err != nil {
+		return decoded, err
+	}
+	if len(topics) == 0 {
+		return decoded, fmt.Errorf("%w: anonymous log", ErrUnknownEvent)
+	}
+
+	event, ok := findEvent(events, topics[0])
+	if !ok {
+		return decoded, makeErrorHex(ErrUnknownEvent, log.Topic0)
+	}
+	decoded.Name = event.Name
+	decoded.Signature = event.Sig
+
+	// Check the shape of the log.
+	var indexed int
+	for _, arg := range event.Inputs {
+		if arg.Indexed {
+			indexed++
+		}
+	}
+	if indexed != len(topics)-1 {
+		return decoded, fmt.Errorf(
+			"%w: %s: want %d indexed arguments, have %d",
+			ErrUndecodableLog,
+			event.Sig,
+			indexed,
+			len(topics)-1,
+		)
+	}
+
+	// Unpack data.
+	values, err := event.Inputs.Unpack(common.FromHex(log.Data))
+	if err != nil {
+		return decoded, fmt.Errorf("%w: %s: %w", ErrUndecodableLog, event.Sig, err)
+	}
+
+	// Merge indexed and non-indexed arguments back into declaration
+	// order.
+	decoded.Args = make([]DecodedArg, len(event.Inputs))
+	topics = topics[1:]
+	for i, arg := range event.Inputs {
+		var value any
+		if arg.Indexed {
+			value, err = decodeTopic(arg, topics[0])
+			if err != nil {
+				return decoded, fmt.Errorf("%w: %s: %w", ErrUndecodableLog, event.Sig, err)
+			}
+			topics = topics[1:]
+		} else {
+			value = values[0]
+			values = values[1:]
+		}
+		decoded.Args[i] = DecodedArg{
+			Name:    argumentName(arg, i),
+			Type:    arg.Type.String(),
+			Indexed: arg.Indexed,
+			Value:   value,
+		}
+	}
+
+	return decoded, nil
+}
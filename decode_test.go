@@ -0,0 +1,93 @@
+package core_test
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blocksignalio/core"
+)
+
+func TestDecodeLog(t *testing.T) {
+	t.Parallel()
+
+	var (
+		from   = common.HexToAddress("0x1111111111111111111111111111111111111111")
+		to     = common.HexToAddress("0x2222222222222222222222222222222222222222")
+		amount = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	)
+
+	transfer := core.FromGethLog(types.Log{ //nolint:exhaustruct
+		Address: common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"),
+		Topics: []common.Hash{
+			core.Transfer.ID,
+			common.BytesToHash(from.Bytes()),
+			common.BytesToHash(to.Bytes()),
+		},
+		Data: common.BigToHash(amount).Bytes(),
+	})
+
+	decoded, err := core.DecodeLog(transfer, core.StandardEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Transfer(address,address,uint256)"; decoded.Signature != want {
+		t.Errorf("signature: have=%s want=%s", decoded.Signature, want)
+	}
+	want := []any{from, to, amount}
+	if len(decoded.Args) != len(want) {
+		t.Fatalf("args: have=%d want=%d", len(decoded.Args), len(want))
+	}
+	for i, arg := range decoded.Args {
+		switch w := want[i].(type) {
+		case *big.Int:
+			if v, ok := arg.Value.(*big.Int); !ok || v.Cmp(w) != 0 {
+				t.Errorf("%s: have=%v want=%v", arg.Name, arg.Value, w)
+			}
+		default:
+			if arg.Value != w {
+				t.Errorf("%s: have=%v want=%v", arg.Name, arg.Value, w)
+			}
+		}
+	}
+
+	args, err := decoded.MarshalArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArgs := `{"amount":"1000000000000000000",` +
+		`"from":"0x1111111111111111111111111111111111111111",` +
+		`"to":"0x2222222222222222222222222222222222222222"}`
+	if diff := cmp.Diff(string(args), wantArgs); diff != "" {
+		t.Error(diff)
+	}
+
+	// ERC-721 transfers share the topic but index the token id.
+	erc721 := transfer
+	erc721.Topic3 = common.BigToHash(big.NewInt(1)).Hex()
+	erc721.Data = ""
+	_, err = core.DecodeLog(erc721, core.StandardEvents())
+	if !errors.Is(err, core.ErrUndecodableLog) {
+		t.Errorf("erc721: have=%v want=%v", err, core.ErrUndecodableLog)
+	}
+
+	// Truncated data.
+	truncated := transfer
+	truncated.Data = "0x01"
+	_, err = core.DecodeLog(truncated, core.StandardEvents())
+	if !errors.Is(err, core.ErrUndecodableLog) {
+		t.Errorf("truncated: have=%v want=%v", err, core.ErrUndecodableLog)
+	}
+
+	// Unknown event.
+	events := map[string]abi.Event{"Approval": core.Approval}
+	_, err = core.DecodeLog(transfer, events)
+	if !errors.Is(err, core.ErrUnknownEvent) {
+		t.Errorf("unknown: have=%v want=%v", err, core.ErrUnknownEvent)
+	}
+}
@@ -1,12 +1,16 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 )
@@ -68,11 +72,30 @@ func (s ContractSource) String() string {
 const (
 	envEtherscanKey = "ETHERSCAN_APIKEY"
 
-	urlContract = "https://api.etherscan.io/api" +
-		"?module=contract" +
-		"&action=%s" +
-		"&%s=%s" +
-		"&apikey=%s"
+	// DefaultEtherscanURL is the API endpoint of the Ethereum mainnet
+	// explorer.
+	DefaultEtherscanURL = "https://api.etherscan.io/api"
+
+	// DefaultUserAgent is sent with every request unless the client
+	// overrides it.
+	DefaultUserAgent = "blocksignalio-core"
+
+	// DefaultEtherscanRateLimit is the number of requests per second
+	// allowed by Etherscan's free tier.
+	DefaultEtherscanRateLimit = 5
+
+	// DefaultEtherscanRetries is the number of times a rate-limited or
+	// otherwise transient failure is retried.
+	DefaultEtherscanRetries = 3
+
+	// DefaultEtherscanBackoff is the delay before the first retry.  It
+	// doubles with each subsequent attempt.
+	DefaultEtherscanBackoff = time.Second
+
+	// DefaultEtherscanTimeout bounds a single request made by a client
+	// created with NewEtherscanClient.  The caller's context may impose
+	// a shorter deadline.
+	DefaultEtherscanTimeout = 30 * time.Second
 )
 
 type etherscanResponse[T any] struct {
@@ -81,11 +104,124 @@ type etherscanResponse[T any] struct {
 	Result  T      `json:"result"`
 }
 
+// +----------------+
+// | EtherscanError |
+// +----------------+
+
+// EtherscanError describes a failed Etherscan request.  It wraps one of
+// ErrRateLimited, ErrInvalidAPIKey, ErrContractNotVerified, ErrNotFound,
+// ErrInvalidResponse or ErrInvalidResponseBody, so callers can test for
+// the failure mode with errors.Is and get at the details with
+// errors.As.
+type EtherscanError struct {
+	// Err is the sentinel error classifying the failure.
+	Err error
+	// HTTPCode is the status code of the HTTP response.
+	HTTPCode int
+	// Status, Message and Result are the fields of the response body.
+	// They are empty if the request failed at the HTTP level.
+	Status  string
+	Message string
+	Result  string
+}
+
+func (e *EtherscanError) Error() string {
+	if e.Status == "" && e.Message == "" {
+		return fmt.Sprintf("%v: code=%d", e.Err, e.HTTPCode)
+	}
+	return fmt.Sprintf(
+		"%v: code=%d status=%s message=%s result=%s",
+		e.Err,
+		e.HTTPCode,
+		e.Status,
+		e.Message,
+		e.Result,
+	)
+}
+
+func (e *EtherscanError) Unwrap() error {
+	return e.Err
+}
+
+// notVerifiedError is the error of a successful response that reports
+// `result` in place of the source of an unverified contract.
+func notVerifiedError(result string) *EtherscanError {
+	return &EtherscanError{
+		Err:      ErrContractNotVerified,
+		HTTPCode: http.StatusOK,
+		Status:   "1",
+		Message:  "OK",
+		Result:   result,
+	}
+}
+
+// Retryable reports whether the request may succeed if sent again.
+func (e *EtherscanError) Retryable() bool {
+	return errors.Is(e.Err, ErrRateLimited) ||
+		http.StatusInternalServerError <= e.HTTPCode
+}
+
+// +-----------------+
+// | EtherscanClient |
+// +-----------------+
+
+// EtherscanClient queries Etherscan, or any explorer exposing an
+// Etherscan-compatible API.  The zero value is not usable; create one
+// with NewEtherscanClient or NewEtherscanClientFromEnv.
+type EtherscanClient struct {
+	// BaseURL is the API endpoint, e.g. DefaultEtherscanURL.
+	BaseURL string
+	// APIKey is sent as the `apikey` query parameter.
+	APIKey string
+	// HTTPClient performs the requests.  If nil, http.DefaultClient
+	// is used.  Requests are also bound to the caller's context.
+	HTTPClient *http.Client
+	// UserAgent is sent as the User-Agent header.  If empty, no
+	// header is set.
+	UserAgent string
+	// RateLimit is the number of requests per second allowed for
+	// APIKey.  Clients using the same endpoint and key share a single
+	// token bucket, which runs at the lowest of their rates.  Zero
+	// disables rate limiting.
+	RateLimit float64
+	// MaxRetries is the number of times a request failing with a
+	// retryable error (rate limiting, server errors, network errors)
+	// is retried.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry.  It doubles
+	// with each attempt.
+	RetryBackoff time.Duration
+}
+
+// NewEtherscanClient returns a client for the Ethereum mainnet explorer
+// authenticated with the given API key.
+func NewEtherscanClient(apikey string) *EtherscanClient {
+	return &EtherscanClient{
+		BaseURL:      DefaultEtherscanURL,
+		APIKey:       apikey,
+		HTTPClient:   &http.Client{Timeout: DefaultEtherscanTimeout}, //nolint:exhaustruct
+		UserAgent:    DefaultUserAgent,
+		RateLimit:    DefaultEtherscanRateLimit,
+		MaxRetries:   DefaultEtherscanRetries,
+		RetryBackoff: DefaultEtherscanBackoff,
+	}
+}
+
+// NewEtherscanClientFromEnv is like NewEtherscanClient, but reads the
+// API key from the ETHERSCAN_APIKEY environment variable.
+func NewEtherscanClientFromEnv() (*EtherscanClient, error) {
+	apikey := os.Getenv(envEtherscanKey)
+	if apikey == "" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsetEnvironmentVar, envEtherscanKey)
+	}
+	return NewEtherscanClient(apikey), nil
+}
+
 // +---------+
 // | Private |
 // +---------+
 
-func makeURL(multipleContracts bool, action string, contracts []string) (string, error) {
+func (c *EtherscanClient) makeURL(multipleContracts bool, action string, contracts []string) (string, error) {
 	// Check action.
 	if action != "getabi" &&
 		action != "getsourcecode" &&
@@ -95,92 +231,197 @@ func makeURL(multipleContracts bool, action string, contracts []string) (string,
 		panic("unrecognized action")
 	}
 
-	// Get API key.
-	apikey := os.Getenv(envEtherscanKey)
-	if apikey == "" {
+	// Check API key.
+	if c.APIKey == "" {
 		return "", fmt.Errorf("%w: %s", ErrUnsetEnvironmentVar, envEtherscanKey)
 	}
 
 	// Now bundle the URL together.
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("parse base url: %w", err)
+	}
 	key := "address"
 	if multipleContracts {
 		key = "contractaddresses"
 	}
-	joined := strings.Join(contracts, ",")
-	return fmt.Sprintf(urlContract, action, key, joined, apikey), nil
+	query := base.Query()
+	query.Set("module", "contract")
+	query.Set("action", action)
+	query.Set(key, strings.Join(contracts, ","))
+	query.Set("apikey", c.APIKey)
+	base.RawQuery = query.Encode()
+	return base.String(), nil
 }
 
-func etherscanGet[T any](multipleContracts bool, action string, addresses []string, out *T) error {
-	// Check addresses.
-	for _, contract := range addresses {
-		if !ValidateAddress(contract) {
-			return makeErrorHex(ErrInvalidContractAddress, contract)
-		}
+func (c *EtherscanClient) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+func (c *EtherscanClient) wait(ctx context.Context) error {
+	if c.RateLimit <= 0 {
+		return nil
+	}
+	return sharedRateLimiter(c.BaseURL, c.APIKey, c.RateLimit).Wait(ctx)
+}
+
+// classifyEtherscan maps the message of a failed response to one of the
+// sentinel errors.  Etherscan reports most failures with HTTP 200 and
+// status "0", so the text is all we have to go on.
+func classifyEtherscan(message, result string) error {
+	text := strings.ToLower(message + " " + result)
+	switch {
+	case strings.Contains(text, "rate limit"):
+		return ErrRateLimited
+	case strings.Contains(text, "invalid api key"),
+		strings.Contains(text, "missing/invalid api key"):
+		return ErrInvalidAPIKey
+	case strings.Contains(text, "not verified"):
+		return ErrContractNotVerified
+	case strings.Contains(text, "no data found"),
+		strings.Contains(text, "no records found"):
+		return ErrNotFound
+	default:
+		return ErrInvalidResponseBody
 	}
+}
 
-	// Make the GET request.
-	url, err := makeURL(multipleContracts, action, addresses)
+// etherscanDo performs a single request.  The boolean result reports
+// whether a failed request is worth retrying.
+func etherscanDo[T any](ctx context.Context, c *EtherscanClient, endpoint string, out *T) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("new request: %w", err)
+	}
+	if c.UserAgent != "" {
+		request.Header.Set("User-Agent", c.UserAgent)
 	}
-	response, err := http.Get(url) //nolint:gosec,noctx
+	response, err := c.httpClient().Do(request)
 	if err != nil {
-		return fmt.Errorf("get: %w", err)
+		if ctx.Err() != nil {
+			return false, fmt.Errorf("context done: %w", ctx.Err())
+		}
+		return true, fmt.Errorf("get: %w", err)
 	}
 	defer response.Body.Close()
 
 	// Check if the request was unsuccessful.
 	if http.StatusBadRequest <= response.StatusCode {
-		return fmt.Errorf("%w: code=%d", ErrInvalidResponse, response.StatusCode)
+		kind := ErrInvalidResponse
+		if response.StatusCode == http.StatusTooManyRequests {
+			kind = ErrRateLimited
+		}
+		e := &EtherscanError{
+			Err:      kind,
+			HTTPCode: response.StatusCode,
+			Status:   "",
+			Message:  "",
+			Result:   "",
+		}
+		return e.Retryable(), e
 	}
 
 	// Read the response content.
 	content, err := io.ReadAll(response.Body)
 	if err != nil {
-		return fmt.Errorf("read: %w", err)
+		return true, fmt.Errorf("read: %w", err)
 	}
 
-	// Parse the JSON response.
-	var body etherscanResponse[T]
+	// Parse the JSON response.  The result is decoded separately
+	// because failed responses carry a string in place of T.
+	var body etherscanResponse[json.RawMessage]
 	err = json.Unmarshal(content, &body)
 	if err != nil {
-		return fmt.Errorf("unmarshal: %w", err)
+		return false, fmt.Errorf("unmarshal: %w", err)
 	}
 
 	if body.Status != "1" || body.Message != "OK" {
-		return fmt.Errorf(
-			"%w: code=%d status=%s message=%s result=%v",
-			ErrInvalidResponseBody,
-			response.StatusCode,
-			body.Status,
-			body.Message,
-			body.Result,
-		)
+		var result string
+		if json.Unmarshal(body.Result, &result) != nil {
+			result = string(body.Result)
+		}
+		e := &EtherscanError{
+			Err:      classifyEtherscan(body.Message, result),
+			HTTPCode: response.StatusCode,
+			Status:   body.Status,
+			Message:  body.Message,
+			Result:   result,
+		}
+		return e.Retryable(), e
+	}
+
+	err = json.Unmarshal(body.Result, out)
+	if err != nil {
+		return false, fmt.Errorf("unmarshal result: %w", err)
+	}
+	return false, nil
+}
+
+func etherscanGet[T any](ctx context.Context, c *EtherscanClient, multipleContracts bool, action string, addresses []string, out *T) error {
+	// Check addresses.
+	for _, contract := range addresses {
+		if !ValidateAddress(contract) {
+			return makeErrorHex(ErrInvalidContractAddress, contract)
+		}
 	}
 
-	*out = body.Result
-	return nil
+	endpoint, err := c.makeURL(multipleContracts, action, addresses)
+	if err != nil {
+		return err
+	}
+
+	// Make the GET request, backing off exponentially while the
+	// failure is transient.
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err = c.wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		retry, err := etherscanDo(ctx, c, endpoint, out)
+		if err == nil || !retry || attempt >= c.MaxRetries {
+			return err
+		}
+
+		err = sleep(ctx, backoff)
+		if err != nil {
+			return err
+		}
+		backoff *= 2
+	}
 }
 
-func etherscanGet1[T any](action, address string, out *T) error {
-	return etherscanGet(false, action, []string{address}, out)
+func etherscanGet1[T any](ctx context.Context, c *EtherscanClient, action, address string, out *T) error {
+	return etherscanGet(ctx, c, false, action, []string{address}, out)
 }
 
-func etherscanGet2[T any](action string, addresses []string, out *T) error {
-	return etherscanGet(true, action, addresses, out)
+func etherscanGet2[T any](ctx context.Context, c *EtherscanClient, action string, addresses []string, out *T) error {
+	return etherscanGet(ctx, c, true, action, addresses, out)
 }
 
-// +--------+
-// | Public |
-// +--------+
+// +---------+
+// | Methods |
+// +---------+
 
-func GetContractABI(address string) (abi.ABI, error) {
-	var (
-		result string
-		parsed abi.ABI
-	)
+// GetContractRawABI returns the ABI of a verified contract as the JSON
+// string served by Etherscan.
+func (c *EtherscanClient) GetContractRawABI(ctx context.Context, address string) (string, error) {
+	var result string
+	err := etherscanGet1(ctx, c, "getabi", address, &result)
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+func (c *EtherscanClient) GetContractABI(ctx context.Context, address string) (abi.ABI, error) {
+	var parsed abi.ABI
 
-	err := etherscanGet1("getabi", address, &result)
+	result, err := c.GetContractRawABI(ctx, address)
 	if err != nil {
 		return parsed, err
 	}
@@ -193,17 +434,17 @@ func GetContractABI(address string) (abi.ABI, error) {
 	return parsed, nil
 }
 
-func GetContractEvents(address string) (map[string]abi.Event, error) {
-	iface, err := GetContractABI(address)
+func (c *EtherscanClient) GetContractEvents(ctx context.Context, address string) (map[string]abi.Event, error) {
+	iface, err := c.GetContractABI(ctx, address)
 	if err != nil {
 		return nil, err
 	}
 	return iface.Events, nil
 }
 
-func GetContractCreation(contracts []string) ([]ContractCreation, error) {
+func (c *EtherscanClient) GetContractCreation(ctx context.Context, contracts []string) ([]ContractCreation, error) {
 	var xs []ContractCreation
-	err := etherscanGet2("getcontractcreation", contracts, &xs)
+	err := etherscanGet2(ctx, c, "getcontractcreation", contracts, &xs)
 	if err != nil {
 		return nil, err
 	}
@@ -218,8 +459,8 @@ func GetContractCreation(contracts []string) ([]ContractCreation, error) {
 	return xs, nil
 }
 
-func GetContractCreation1(contract string) (ContractCreation, error) {
-	xs, err := GetContractCreation([]string{contract})
+func (c *EtherscanClient) GetContractCreation1(ctx context.Context, contract string) (ContractCreation, error) {
+	xs, err := c.GetContractCreation(ctx, []string{contract})
 	if err != nil {
 		var empty ContractCreation
 		return empty, err
@@ -231,11 +472,67 @@ func GetContractCreation1(contract string) (ContractCreation, error) {
 	return xs[0], nil
 }
 
-func GetContractSource(address string) ([]ContractSource, error) {
+func (c *EtherscanClient) GetContractSource(ctx context.Context, address string) ([]ContractSource, error) {
 	var ans []ContractSource
-	err := etherscanGet1("getsourcecode", address, &ans)
+	err := etherscanGet1(ctx, c, "getsourcecode", address, &ans)
 	if err != nil {
 		return nil, err
 	}
+	// Unverified contracts are reported as a successful response with
+	// the error message in place of the ABI.
+	for _, source := range ans {
+		if errors.Is(classifyEtherscan("", source.ABI), ErrContractNotVerified) {
+			return nil, notVerifiedError(source.ABI)
+		}
+	}
 	return ans, nil
 }
+
+// +--------+
+// | Public |
+// +--------+
+
+// The functions below use a client configured from the environment.
+// They are kept for compatibility; prefer an explicit EtherscanClient.
+
+func GetContractABI(ctx context.Context, address string) (abi.ABI, error) {
+	c, err := NewEtherscanClientFromEnv()
+	if err != nil {
+		var empty abi.ABI
+		return empty, err
+	}
+	return c.GetContractABI(ctx, address)
+}
+
+func GetContractEvents(ctx context.Context, address string) (map[string]abi.Event, error) {
+	c, err := NewEtherscanClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetContractEvents(ctx, address)
+}
+
+func GetContractCreation(ctx context.Context, contracts []string) ([]ContractCreation, error) {
+	c, err := NewEtherscanClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetContractCreation(ctx, contracts)
+}
+
+func GetContractCreation1(ctx context.Context, contract string) (ContractCreation, error) {
+	c, err := NewEtherscanClientFromEnv()
+	if err != nil {
+		var empty ContractCreation
+		return empty, err
+	}
+	return c.GetContractCreation1(ctx, contract)
+}
+
+func GetContractSource(ctx context.Context, address string) ([]ContractSource, error) {
+	c, err := NewEtherscanClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetContractSource(ctx, address)
+}
@@ -1,11 +1,18 @@
 package core_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/coretest"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -13,7 +20,6 @@ func TestGetContractABI(t *testing.T) {
 	t.Parallel()
 
 	const (
-		weth = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
 		want = ("" +
 			"event Approval(address indexed src, address indexed guy, uint256 wad) " +
 			"event Deposit(address indexed dst, uint256 wad) " +
@@ -21,7 +27,8 @@ func TestGetContractABI(t *testing.T) {
 			"event Withdrawal(address indexed src, uint256 wad)")
 	)
 
-	abi, err := core.GetContractABI(weth)
+	client := coretest.NewEtherscan().Client(t)
+	abi, err := client.GetContractABI(context.Background(), coretest.WETH)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -35,3 +42,182 @@ func TestGetContractABI(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestEtherscanClient(t *testing.T) {
+	t.Parallel()
+
+	const (
+		weth   = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+		apikey = "TESTKEY"
+		agent  = "core-test"
+		result = `[{"anonymous":false,"inputs":[` +
+			`{"indexed":true,"name":"dst","type":"address"},` +
+			`{"indexed":false,"name":"wad","type":"uint256"}],` +
+			`"name":"Deposit","type":"event"}]`
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if have := query.Get("apikey"); have != apikey {
+			t.Errorf("apikey: have=%s want=%s", have, apikey)
+		}
+		if have := query.Get("address"); have != weth {
+			t.Errorf("address: have=%s want=%s", have, weth)
+		}
+		if have := r.UserAgent(); have != agent {
+			t.Errorf("user agent: have=%s want=%s", have, agent)
+		}
+		body, _ := json.Marshal(map[string]string{
+			"status":  "1",
+			"message": "OK",
+			"result":  result,
+		})
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	client := core.NewEtherscanClient(apikey)
+	client.BaseURL = server.URL
+	client.HTTPClient = server.Client()
+	client.UserAgent = agent
+
+	events, err := client.GetContractEvents(context.Background(), weth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "event Deposit(address indexed dst, uint256 wad)"
+	if diff := cmp.Diff(events["Deposit"].String(), want); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestEtherscanClientDeadline(t *testing.T) {
+	t.Parallel()
+
+	const weth = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client := core.NewEtherscanClient("TESTKEY")
+	client.BaseURL = server.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := client.GetContractABI(ctx, weth)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error: have=%v want=%v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestEtherscanClientRetry(t *testing.T) {
+	t.Parallel()
+
+	const weth = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+
+	tests := []struct {
+		result    string
+		wantErr   error
+		wantCalls int
+	}{
+		{"Max rate limit reached", nil, 3},
+		{"Contract source code not verified", core.ErrContractNotVerified, 1},
+		{"Invalid API Key", core.ErrInvalidAPIKey, 1},
+	}
+
+	for _, tt := range tests {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls++
+			body := map[string]string{"status": "0", "message": "NOTOK", "result": tt.result}
+			if calls == 3 {
+				body = map[string]string{"status": "1", "message": "OK", "result": "[]"}
+			}
+			content, _ := json.Marshal(body)
+			_, _ = w.Write(content)
+		}))
+
+		client := core.NewEtherscanClient("TESTKEY")
+		client.BaseURL = server.URL
+		client.RateLimit = 0
+		client.RetryBackoff = time.Millisecond
+
+		_, err := client.GetContractABI(context.Background(), weth)
+		server.Close()
+
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error: have=%v want=%v", tt.result, err, tt.wantErr)
+		}
+		if tt.wantErr != nil {
+			var e *core.EtherscanError
+			if !errors.As(err, &e) || e.Result != tt.result || e.Status != "0" {
+				t.Errorf("%s: EtherscanError: have=%#v", tt.result, e)
+			}
+			// Each failure mode matches its own sentinel only.
+			if errors.Is(err, core.ErrInvalidResponseBody) {
+				t.Errorf("%s: error: have=%v want=%v", tt.result, err, tt.wantErr)
+			}
+		}
+		if calls != tt.wantCalls {
+			t.Errorf("%s: calls: have=%d want=%d", tt.result, calls, tt.wantCalls)
+		}
+	}
+}
+
+func TestEtherscanClientSharedRateLimit(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"1","message":"OK","result":"[]"}`))
+	}))
+	defer server.Close()
+
+	fast := core.NewEtherscanClient("TESTKEY")
+	fast.BaseURL = server.URL
+	fast.RateLimit = 1000
+	slow := core.NewEtherscanClient("TESTKEY")
+	slow.BaseURL = server.URL
+	slow.RateLimit = 2
+
+	// The bucket, created at the fast rate, slows down for the slow
+	// client: its burst of 2 is spent, then the next request waits.
+	ctx := context.Background()
+	_, err := fast.GetContractRawABI(ctx, coretest.WETH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for range 3 {
+		_, err = slow.GetContractRawABI(ctx, coretest.WETH)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if have, want := time.Since(start), 400*time.Millisecond; have < want {
+		t.Errorf("elapsed: have=%v want>=%v", have, want)
+	}
+}
+
+func TestGetContractSourceNotVerified(t *testing.T) {
+	t.Parallel()
+
+	const unverified = "0x0000000000000000000000000000000000000001"
+
+	client := coretest.NewEtherscan().Client(t)
+	_, err := client.GetContractSource(context.Background(), unverified)
+	if !errors.Is(err, core.ErrContractNotVerified) {
+		t.Errorf("error: have=%v want=%v", err, core.ErrContractNotVerified)
+	}
+	var e *core.EtherscanError
+	if !errors.As(err, &e) || e.HTTPCode != http.StatusOK {
+		t.Errorf("EtherscanError: have=%#v", e)
+	}
+}
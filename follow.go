@@ -0,0 +1,233 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"gorm.io/gorm"
+)
+
+var errSubscriptionDropped = errors.New("subscription dropped")
+
+// DefaultPollInterval is about the block time of Ethereum mainnet.
+const DefaultPollInterval = 12 * time.Second
+
+// FollowOptions tunes Follow.
+type FollowOptions struct {
+	// Backfill applies to the range backfills run to catch up.
+	Backfill BackfillOptions
+	// PollInterval is how often the node is asked for a new head when
+	// it does not support subscriptions, how often the checkpoint is
+	// moved forward when it does, and how long to wait before
+	// resubscribing after a disconnect.
+	PollInterval time.Duration
+}
+
+func DefaultFollowOptions() FollowOptions {
+	return FollowOptions{
+		Backfill:     DefaultBackfillOptions(),
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// Follow backfills the logs of `contract` and then keeps ingesting new
+// ones until the context is done.  Over a websocket connection, logs
+// are streamed with eth_subscribe as soon as they are mined, and logs
+// removed by a reorg are deleted; otherwise the node is polled for new
+// heads.  Either way, a range backfill runs periodically to move the
+// checkpoint forward, and after a disconnect to close the gap, once the
+// logs streamed past the checkpoint are discarded.
+func Follow(ctx context.Context, db *gorm.DB, contract string, opts FollowOptions) error {
+	if !ValidateAddress(contract) {
+		return makeErrorHex(ErrInvalidContractAddress, contract)
+	}
+
+	env, err := newEnv(ctx, db, opts.Backfill)
+	if err != nil {
+		return err
+	}
+	defer env.node.Close()
+
+	return env.follow(ctx, contract, opts.PollInterval)
+}
+
+func (e *backfillEnv) follow(ctx context.Context, contract string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	for {
+		err := e.discardUnconfirmed(ctx, contract)
+		if err != nil {
+			return err
+		}
+		err = e.backfill(ctx, contract)
+		if err != nil {
+			return err
+		}
+
+		events, err := e.eventSet(ctx, []string{contract})
+		if err != nil {
+			return err
+		}
+
+		err = e.stream(ctx, contract, events, interval)
+		if errors.Is(err, rpc.ErrNotificationsUnsupported) {
+			return e.poll(ctx, contract, interval)
+		}
+		if !errors.Is(err, errSubscriptionDropped) {
+			return err
+		}
+
+		// The subscription dropped.  Wait a little, then backfill
+		// whatever was missed and subscribe again.
+		err = sleep(ctx, interval)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// discardUnconfirmed deletes the logs of `contract` past the highest
+// checkpoint covering it, i.e. those streamed.  Any of them may have
+// been removed by a reorg while the connection was down, along with the
+// notification, and would then shadow the canonical log at its position
+// or of its transaction, which the range backfill skips as conflicting.
+// They come back with the range backfill once confirmed.
+func (e *backfillEnv) discardUnconfirmed(ctx context.Context, contract string) error {
+	chainID, err := e.chainID(ctx)
+	if err != nil {
+		return err
+	}
+	var states []BackfillState
+	result := whereCovers(e.db.WithContext(ctx), []string{prepareHex(contract)}).
+		Select("last_block").
+		Where("chain_id = ?", chainID).
+		Find(&states)
+	if result.Error != nil {
+		return fmt.Errorf("find states: %w", result.Error)
+	}
+	if len(states) == 0 {
+		// Nothing was streamed yet.
+		return nil
+	}
+	var last uint64
+	for _, state := range states {
+		last = max(last, state.LastBlock)
+	}
+
+	filter := LogFilter{ //nolint:exhaustruct
+		Addresses: []string{contract},
+		Topics:    e.opts.Topics,
+		FromBlock: last + 1,
+	}
+	query, err := filter.where(e.db.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	result = query.Delete(&Log{}) //nolint:exhaustruct
+	if result.Error != nil {
+		return fmt.Errorf("delete unconfirmed: %w", result.Error)
+	}
+	return nil
+}
+
+// poll runs a range backfill whenever the head moves.
+func (e *backfillEnv) poll(ctx context.Context, contract string, interval time.Duration) error {
+	var last uint64
+	for {
+		err := sleep(ctx, interval)
+		if err != nil {
+			return err
+		}
+
+		head, err := e.head(ctx)
+		if err != nil {
+			return err
+		}
+		if head == last {
+			continue
+		}
+		last = head
+
+		err = e.backfill(ctx, contract)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stream ingests logs from a subscription.  If the subscription fails,
+// the error returned wraps errSubscriptionDropped.
+func (e *backfillEnv) stream(ctx context.Context, contract string, events eventSet, interval time.Duration) error {
+	topics, err := NormalizeTopics(e.opts.Topics)
+	if err != nil {
+		return err
+	}
+	query := ethereum.FilterQuery{
+		BlockHash: nil,
+		FromBlock: nil,
+		ToBlock:   nil,
+		Addresses: []common.Address{common.HexToAddress(contract)},
+		Topics:    makeTopicFilter(topics),
+	}
+	ch := make(chan types.Log, 256)
+	sub, err := e.node.client.SubscribeFilterLogs(ctx, query, ch)
+	if err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context done: %w", ctx.Err())
+		case err := <-sub.Err():
+			return fmt.Errorf("%w: %w", errSubscriptionDropped, err)
+		case <-ticker.C:
+			err := e.backfill(ctx, contract)
+			if err != nil {
+				return err
+			}
+		case log := <-ch:
+			err := e.ingest(ctx, log, events)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ingest stores a streamed log, or deletes it if it was removed by a
+// reorg.  The checkpoint is left alone: it only covers confirmed
+// blocks, which the range backfill takes care of.
+func (e *backfillEnv) ingest(ctx context.Context, log types.Log, events eventSet) error {
+	x := FromGethLog(log)
+	return e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if log.Removed {
+			result := tx.
+				Where("address = ? AND block_number = ? AND index = ?", x.Address, x.BlockNumber, x.Index).
+				Delete(&Log{}) //nolint:exhaustruct
+			if result.Error != nil {
+				return fmt.Errorf("delete: %w", result.Error)
+			}
+			return nil
+		}
+
+		xs := []Log{x}
+		err := storeLogs(tx, xs)
+		if err != nil {
+			return err
+		}
+		return storeDecodings(tx, events.decode(xs))
+	})
+}
@@ -0,0 +1,66 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/coretest"
+)
+
+func TestFollowDiscardsUnconfirmed(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t, 3)
+	f.chain.AddLogs(transfer(1, 0))
+	f.run(t, coretest.WETH)
+
+	// A log was streamed at +5, then the chain reorganized from +4
+	// while disconnected, and the removal was missed.
+	orphan := core.FromGethLog(transfer(5, 0))
+	orphan.BlockHash = f.chain.BlockHash(coretest.WETHCreationBlock + 5)
+	err := f.db.Create(&orphan).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.chain.Reorg(coretest.WETHCreationBlock+4, transfer(5, 0))
+	f.chain.SetHead(coretest.WETHCreationBlock + 10)
+
+	// Over HTTP, Follow polls.  Stop once caught up.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node, err := core.Dial(ctx, f.chain.URL(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	f.backfiller.Node = node
+	f.backfiller.Progress = func(p core.BackfillProgress) {
+		if p.ToBlock == f.chain.Head() {
+			cancel()
+		}
+	}
+	err = f.backfiller.Follow(ctx, coretest.WETH, time.Millisecond)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("follow: have=%v want=%v", err, context.Canceled)
+	}
+
+	if diff := cmp.Diff([]string{"1/0", "5/0"}, f.logs(t)); diff != "" {
+		t.Errorf("logs (-want +have):\n%s", diff)
+	}
+	var x core.Log
+	err = f.db.Take(&x, "block_number = ?", coretest.WETHCreationBlock+5).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := x.BlockHash, f.chain.BlockHash(x.BlockNumber); have != want {
+		t.Errorf("block hash: have=%s want=%s", have, want)
+	}
+	if have := f.count(t, &core.LogDecoding{}); have != 2 { //nolint:exhaustruct
+		t.Errorf("decodings: have=%d want=2", have)
+	}
+}
@@ -0,0 +1,232 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// LogOrder is the order in which LogFilter returns logs.
+type LogOrder int
+
+const (
+	// NewestFirst is the order of SelectLogs.
+	NewestFirst LogOrder = iota
+	OldestFirst
+)
+
+// LogFilter selects stored logs.  Each field left to its zero value
+// matches every log; the fields set must all match.
+type LogFilter struct {
+	// Addresses, if any, are the contracts the logs may come from.
+	Addresses []string
+	// Topics lists the accepted topics of each position, as in
+	// LogQuery: an empty position accepts anything.
+	Topics [][]string
+	// FromBlock and ToBlock bound the blocks, inclusively.  A zero
+	// ToBlock means no upper bound.
+	FromBlock uint64
+	ToBlock   uint64
+	// TxHash, if set, is the transaction the logs were emitted by.
+	TxHash string
+	Order  LogOrder
+	// Page and PageSize paginate Find as in Paginate.
+	Page     int
+	PageSize int
+}
+
+// Find returns the logs matching the filter.
+func (f LogFilter) Find(db *gorm.DB) ([]Log, error) {
+	query, err := f.where(db)
+	if err != nil {
+		return nil, err
+	}
+	if f.Order == OldestFirst {
+		query = query.Order("block_number asc, index asc")
+	} else {
+		query = query.Order("block_number desc, index desc")
+	}
+	query, err = Paginate(query, f.Page, f.PageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var xs []Log
+	result := query.Find(&xs)
+	if result.Error != nil {
+		return xs, fmt.Errorf("find: %w", result.Error)
+	}
+	return xs, nil
+}
+
+// FindAfter is like Find, but pages through the logs, most recent
+// first, with cursors rather than offsets, which stays fast on deep
+// pages and consistent while logs are inserted.  An empty `cursor`
+// starts with the most recent logs; otherwise it is one of the cursors
+// of the page returned by a previous call.  Order and Page are ignored.
+func (f LogFilter) FindAfter(db *gorm.DB, cursor string) (CursorPage, error) {
+	var page CursorPage
+
+	// Validate input.
+	var at LogCursor
+	if cursor != "" {
+		var err error
+		at, err = ParseLogCursor(cursor)
+		if err != nil {
+			return page, err
+		}
+	}
+	query, err := f.where(db)
+	if err != nil {
+		return page, err
+	}
+
+	// Prepare query.  One more log than asked for tells whether there
+	// is another page.
+	switch {
+	case cursor == "":
+		query = query.Order("block_number desc, index desc")
+	case at.Backward:
+		query = query.
+			Where("(block_number, index) > (?, ?)", at.BlockNumber, at.Index).
+			Order("block_number asc, index asc")
+	default:
+		query = query.
+			Where("(block_number, index) < (?, ?)", at.BlockNumber, at.Index).
+			Order("block_number desc, index desc")
+	}
+	limit := f.PageSize
+	if limit > 0 {
+		query = query.Limit(limit + 1)
+	}
+
+	// Execute query.
+	var xs []Log
+	result := query.Find(&xs)
+	if result.Error != nil {
+		return page, fmt.Errorf("find: %w", result.Error)
+	}
+	more := limit > 0 && len(xs) > limit
+	if more {
+		xs = xs[:limit]
+	}
+	if at.Backward {
+		slices.Reverse(xs)
+	}
+	page.Logs = xs
+	if len(xs) == 0 {
+		// Nothing past the cursor: the way back is the page at that
+		// end, which holds the log at the cursor.
+		switch {
+		case cursor == "":
+		case at.Backward:
+			page.Next = LogCursor{BlockNumber: at.BlockNumber + 1, Index: 0, Backward: false}.String()
+		default:
+			page.Prev = LogCursor{BlockNumber: 0, Index: 0, Backward: true}.String()
+		}
+		return page, nil
+	}
+
+	// Coming from one side means there are logs on that side.
+	first, last := xs[0], xs[len(xs)-1]
+	if (more && !at.Backward) || (cursor != "" && at.Backward) {
+		page.Next = LogCursor{BlockNumber: last.BlockNumber, Index: last.Index, Backward: false}.String()
+	}
+	if (more && at.Backward) || (cursor != "" && !at.Backward) {
+		page.Prev = LogCursor{BlockNumber: first.BlockNumber, Index: first.Index, Backward: true}.String()
+	}
+	return page, nil
+}
+
+// Count returns the number of logs matching the filter, regardless of
+// pagination.
+func (f LogFilter) Count(db *gorm.DB) (int64, error) {
+	query, err := f.where(db)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	result := query.Count(&n)
+	if result.Error != nil {
+		return 0, fmt.Errorf("count: %w", result.Error)
+	}
+	return n, nil
+}
+
+// Validate checks every field of the filter without querying.
+func (f LogFilter) Validate() error {
+	_, _, err := f.normalize()
+	return err
+}
+
+// normalize validates the filter and returns its addresses and topics
+// in canonical form.
+func (f LogFilter) normalize() ([]string, [][]string, error) {
+	addresses := make([]string, 0, len(f.Addresses))
+	seen := make(map[string]bool, len(f.Addresses))
+	for _, address := range f.Addresses {
+		if !ValidateAddress(address) {
+			return nil, nil, makeErrorHex(ErrInvalidContractAddress, address)
+		}
+		address = prepareHex(address)
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	topics, err := NormalizeTopics(f.Topics)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.ToBlock != 0 && f.FromBlock > f.ToBlock {
+		return nil, nil, fmt.Errorf("%w: [%d, %d]", ErrInvalidBlockRange, f.FromBlock, f.ToBlock)
+	}
+	if f.TxHash != "" && !ValidateTopic(f.TxHash) {
+		return nil, nil, makeErrorHex(ErrInvalidTxHash, f.TxHash)
+	}
+	err = validatePage(f.Page, f.PageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return addresses, topics, nil
+}
+
+// where validates the filter and returns a query on the logs matching
+// it.  Sets of one value are compared with = rather than IN, so that
+// the planner picks the indexes on address and tx_hash.
+func (f LogFilter) where(db *gorm.DB) (*gorm.DB, error) {
+	addresses, topics, err := f.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepare query.
+	query := db.Model(&Log{}) //nolint:exhaustruct
+	query = whereIn(query, "address", addresses)
+	for i, position := range topics {
+		query = whereIn(query, fmt.Sprintf("topic%d", i), position)
+	}
+	if f.FromBlock != 0 {
+		query = query.Where("block_number >= ?", f.FromBlock)
+	}
+	if f.ToBlock != 0 {
+		query = query.Where("block_number <= ?", f.ToBlock)
+	}
+	if f.TxHash != "" {
+		query = query.Where("tx_hash = ?", prepareHex(f.TxHash))
+	}
+	return query, nil
+}
+
+func whereIn(query *gorm.DB, column string, values []string) *gorm.DB {
+	switch len(values) {
+	case 0:
+		return query
+	case 1:
+		return query.Where(column+" = ?", values[0])
+	default:
+		return query.Where(column+" IN ?", values)
+	}
+}
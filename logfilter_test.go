@@ -0,0 +1,109 @@
+package core_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/coretest"
+)
+
+func TestLogFilterValidate(t *testing.T) {
+	t.Parallel()
+
+	const (
+		address = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+		topic   = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	)
+	tests := []struct { //nolint:exhaustruct
+		filter core.LogFilter
+		want   error
+	}{
+		{core.LogFilter{}, nil},
+		{core.LogFilter{Addresses: []string{address, address}, Topics: [][]string{nil, {topic}}}, nil},
+		{core.LogFilter{FromBlock: 2, ToBlock: 2, TxHash: topic}, nil},
+		{core.LogFilter{FromBlock: 2}, nil},
+		{core.LogFilter{Addresses: []string{"0x1234"}}, core.ErrInvalidContractAddress},
+		{core.LogFilter{Topics: [][]string{nil, nil, {"0x1234"}}}, core.ErrInvalidTopic},
+		{core.LogFilter{Topics: make([][]string, 5)}, core.ErrTooManyTopics},
+		{core.LogFilter{FromBlock: 3, ToBlock: 2}, core.ErrInvalidBlockRange},
+		{core.LogFilter{TxHash: address}, core.ErrInvalidTxHash},
+		{core.LogFilter{Page: -1}, core.ErrNegativePage},
+		{core.LogFilter{Page: math.MaxInt, PageSize: 1}, nil},
+		{core.LogFilter{Page: math.MaxInt / 100, PageSize: 100}, nil},
+		{core.LogFilter{Page: math.MaxInt/100 + 1, PageSize: 100}, core.ErrPageOutOfRange},
+	}
+	for i, test := range tests {
+		err := test.filter.Validate()
+		if !errors.Is(err, test.want) {
+			t.Errorf("%d: have=%v want=%v", i, err, test.want)
+		}
+	}
+}
+
+func TestLogFilterFind(t *testing.T) {
+	t.Parallel()
+
+	const (
+		usdc     = "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+		approval = "0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"
+	)
+	db := coretest.DB(t)
+	xs := []types.Log{
+		transfer(1, 0),
+		transfer(2, 0),
+		coretest.MakeLog(coretest.WETH, coretest.WETHCreationBlock+2, 1, approval),
+		transferOf(usdc, 3, 0),
+	}
+	for _, x := range xs {
+		y := core.FromGethLog(x)
+		err := db.Create(&y).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct { //nolint:exhaustruct
+		name   string
+		filter core.LogFilter
+		want   []string
+	}{
+		{"all", core.LogFilter{}, []string{"3/0", "2/1", "2/0", "1/0"}},
+		{"contract", core.LogFilter{Addresses: []string{coretest.WETH}}, []string{"2/1", "2/0", "1/0"}},
+		{"event", core.LogFilter{Addresses: []string{coretest.WETH}, Topics: [][]string{{transferTopic}}}, []string{"2/0", "1/0"}},
+		{"events", core.LogFilter{Topics: [][]string{{transferTopic, approval}}}, []string{"3/0", "2/1", "2/0", "1/0"}},
+		{"blocks", core.LogFilter{FromBlock: coretest.WETHCreationBlock + 2, ToBlock: coretest.WETHCreationBlock + 2}, []string{"2/1", "2/0"}},
+		{"transaction", core.LogFilter{TxHash: xs[1].TxHash.Hex()}, []string{"2/0"}},
+		{"oldest first", core.LogFilter{Order: core.OldestFirst, PageSize: 2, Page: 1}, []string{"2/1", "3/0"}},
+	}
+	for _, test := range tests {
+		logs, err := test.filter.Find(db)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		have := make([]string, len(logs))
+		for i, x := range logs {
+			have[i] = position(x.BlockNumber, x.Index)
+		}
+		if diff := cmp.Diff(test.want, have); diff != "" {
+			t.Errorf("%s: logs (-want +have):\n%s", test.name, diff)
+		}
+
+		// Count ignores pagination.
+		n, err := test.filter.Count(db)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		want := int64(len(test.want))
+		if test.filter.PageSize != 0 {
+			want = int64(len(xs))
+		}
+		if n != want {
+			t.Errorf("%s: count: have=%d want=%d", test.name, n, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationLock is the key of the Postgres advisory lock held while the
+// schema is migrated, so that processes starting together migrate it
+// once.
+const migrationLock = 0x626c6f636b736967
+
+// migrationPoll is how often a process waiting for the migrations of
+// another tries to take the lock again.
+const migrationPoll = 100 * time.Millisecond
+
+// SchemaMigration records a migration applied to the database.
+type SchemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// Migration changes the schema in ways AutoMigrate does not, such as
+// adding indexes that no model declares.  Migrations are applied in
+// order of Version, and recorded once applied.  Up runs outside a
+// transaction, so that it can create indexes concurrently, without
+// blocking writes; it starts one itself if it needs one.  It must be
+// safe to run again after failing midway.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(db *gorm.DB) error
+}
+
+// migrations are the versioned migrations.  Append to the list; never
+// edit or reorder an entry that was released.
+//
+//nolint:gochecknoglobals
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "log query indexes",
+		Up: func(db *gorm.DB) error {
+			// Logs of a contract by event, as selected by SelectLogs.
+			err := createIndex(db, "idx_logs_address_topic0", "logs (address, topic0, block_number)")
+			if err != nil {
+				return err
+			}
+			// Logs by participant, e.g. the sender and recipient of a
+			// Transfer.
+			err = createIndex(db, "idx_logs_topic1", "logs (topic1)")
+			if err != nil {
+				return err
+			}
+			return createIndex(db, "idx_logs_topic2", "logs (topic2)")
+		},
+	},
+}
+
+// Migrate brings the schema of `db` up to date: AutoMigrate creates the
+// tables and columns of the models, then the migrations not applied yet
+// run in order.  All of it holds an advisory lock, on a connection of
+// its own, which other processes wait for without holding a snapshot.
+// Only writers need to migrate: readers would wait on the lock, or on
+// the migrations themselves.
+func Migrate(db *gorm.DB) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		// Statements chained on `conn` would share their conditions;
+		// start each afresh, on the same connection.
+		conn = conn.Session(&gorm.Session{NewDB: true}) //nolint:exhaustruct
+		err := lockMigrations(conn)
+		if err != nil {
+			return err
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLock)
+
+		err = conn.AutoMigrate(&Log{}, &LogDecoding{}, &BackfillState{}, &BackfillShard{}, &ContractABI{}, &Events{}, &SchemaMigration{}) //nolint:exhaustruct
+		if err != nil {
+			return fmt.Errorf("auto migrate: %w", err)
+		}
+		for _, m := range migrations {
+			err = applyMigration(conn, m)
+			if err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// lockMigrations takes the advisory lock of migrations for the session
+// of `conn`.  It polls rather than waiting in pg_advisory_lock: a
+// statement waiting for the lock holds a snapshot, which the concurrent
+// index builds of the holder would wait for in turn.
+func lockMigrations(conn *gorm.DB) error {
+	for {
+		var locked bool
+		err := conn.Raw("SELECT pg_try_advisory_lock(?)", migrationLock).Scan(&locked).Error
+		if err != nil {
+			return fmt.Errorf("lock: %w", err)
+		}
+		if locked {
+			return nil
+		}
+		err = sleep(conn.Statement.Context, migrationPoll)
+		if err != nil {
+			return fmt.Errorf("lock: %w", err)
+		}
+	}
+}
+
+// applyMigration applies `m` unless it already was.
+func applyMigration(db *gorm.DB, m Migration) error {
+	var applied SchemaMigration
+	err := db.Take(&applied, "version = ?", m.Version).Error
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("take: %w", err)
+	}
+
+	err = m.Up(db)
+	if err != nil {
+		return err
+	}
+	applied = SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}
+	err = db.Create(&applied).Error
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	return nil
+}
+
+// createIndex creates the index `name` on `on`, e.g. "logs (topic1)",
+// concurrently.  A concurrent build that failed leaves an invalid index
+// behind, which is dropped and built again.
+func createIndex(db *gorm.DB, name, on string) error {
+	var invalid bool
+	err := db.
+		Raw("SELECT NOT indisvalid FROM pg_index WHERE indexrelid = to_regclass(?)", name).
+		Scan(&invalid).Error
+	if err != nil {
+		return fmt.Errorf("check index %s: %w", name, err)
+	}
+	if invalid {
+		err = db.Exec("DROP INDEX CONCURRENTLY IF EXISTS " + name).Error
+		if err != nil {
+			return fmt.Errorf("drop invalid index %s: %w", name, err)
+		}
+	}
+	err = db.Exec("CREATE INDEX CONCURRENTLY IF NOT EXISTS " + name + " ON " + on).Error
+	if err != nil {
+		return fmt.Errorf("create index %s: %w", name, err)
+	}
+	return nil
+}
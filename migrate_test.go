@@ -0,0 +1,195 @@
+package core_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/coretest"
+)
+
+// baselineLog is the Log model from before any migration, the only
+// table then.
+type baselineLog struct {
+	ID          uint64 `gorm:"primaryKey"`
+	Address     string `gorm:"uniqueIndex:idx_logs_abi;not null"`
+	Topic0      string
+	Topic1      string
+	Topic2      string
+	Topic3      string
+	Data        string
+	BlockNumber uint64 `gorm:"uniqueIndex:idx_logs_abi;not null"`
+	TxHash      string `gorm:"uniqueIndex:idx_logs_hi;not null"`
+	TxIndex     uint   `gorm:"not null"`
+	Index       uint   `gorm:"uniqueIndex:idx_logs_abi;uniqueIndex:idx_logs_hi;not null"`
+}
+
+func (baselineLog) TableName() string {
+	return "logs"
+}
+
+// connect connects to an empty schema, without migrating it.
+func connect(t *testing.T) *gorm.DB {
+	t.Helper()
+	base, schema := coretest.EmptyDB(t)
+	db, err := gorm.Open(postgres.Open(coretest.SchemaURL(base, schema)), &gorm.Config{ //nolint:exhaustruct
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	return db
+}
+
+// checkSchema checks that every migration was recorded once, and that
+// the indexes they create are valid.
+func checkSchema(t *testing.T, db *gorm.DB) []core.SchemaMigration {
+	t.Helper()
+	var applied []core.SchemaMigration
+	err := db.Order("version").Find(&applied).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Version != 1 || applied[0].Name != "log query indexes" {
+		t.Errorf("migrations: have=%+v want version 1", applied)
+	}
+
+	var n int
+	err = db.Raw(
+		"SELECT count(*) FROM pg_index WHERE indisvalid AND indexrelid IN (to_regclass(?), to_regclass(?), to_regclass(?))",
+		"idx_logs_address_topic0", "idx_logs_topic1", "idx_logs_topic2",
+	).Scan(&n).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("valid indexes: have=%d want=3", n)
+	}
+	return applied
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	db := connect(t)
+	err := core.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := checkSchema(t, db)
+
+	// Migrating again changes nothing.
+	err = core.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := checkSchema(t, db)
+	if len(applied) == 1 && len(again) == 1 && !again[0].AppliedAt.Equal(applied[0].AppliedAt) {
+		t.Errorf("applied at: have=%s want=%s", again[0].AppliedAt, applied[0].AppliedAt)
+	}
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	t.Parallel()
+
+	// The indexes are built concurrently while the others wait for the
+	// lock, which would deadlock if they held a snapshot meanwhile.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	db := connect(t).WithContext(ctx)
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = core.Migrate(db)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkSchema(t, db)
+}
+
+func TestMigrateFromBaseline(t *testing.T) {
+	t.Parallel()
+
+	db := connect(t)
+	err := db.AutoMigrate(&baselineLog{}) //nolint:exhaustruct
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := core.FromGethLog(transfer(1, 0))
+	err = db.Create(&baselineLog{
+		ID:          0,
+		Address:     old.Address,
+		Topic0:      old.Topic0,
+		Topic1:      old.Topic1,
+		Topic2:      old.Topic2,
+		Topic3:      old.Topic3,
+		Data:        old.Data,
+		BlockNumber: old.BlockNumber,
+		TxHash:      old.TxHash,
+		TxIndex:     old.TxIndex,
+		Index:       old.Index,
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = core.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSchema(t, db)
+	var x core.Log
+	err = db.Take(&x).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.TxHash != old.TxHash || x.BlockHash != "" {
+		t.Errorf("log: have=%+v want=%+v without block hash", x, old)
+	}
+}
+
+func TestMigrateInvalidIndex(t *testing.T) {
+	t.Parallel()
+
+	db := connect(t)
+	err := core.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What a failed concurrent build leaves behind.  Marking an index
+	// invalid takes a superuser.
+	err = db.Exec("DELETE FROM schema_migrations").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Exec("UPDATE pg_index SET indisvalid = false WHERE indexrelid = to_regclass('idx_logs_topic1')").Error
+	if err != nil {
+		t.Skipf("cannot invalidate index: %v", err)
+	}
+
+	err = core.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSchema(t, db)
+}
@@ -1,8 +1,11 @@
 package core
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
@@ -13,17 +16,152 @@ func prepareHex(hex string) string {
 	return strings.ToLower(SanitizeHex(hex))
 }
 
+// +-------------+
+// | ContractABI |
+// +-------------+
+
+// ContractABI is a raw ABI as fetched from Etherscan.  Unverified
+// contracts are stored too, with an empty ABI, so that they are not
+// looked up again until the entry expires.
+type ContractABI struct {
+	// Lowercase, 0x-prefixed contract address.
+	Address   string    `gorm:"primaryKey" json:"address"`
+	ABI       string    `gorm:"not null" json:"abi"`
+	Verified  bool      `gorm:"not null" json:"verified"`
+	FetchedAt time.Time `gorm:"not null" json:"fetchedAt"`
+}
+
 // +--------+
 // | Events |
 // +--------+
 
+// Events is the set of events declared by a contract, stored so that
+// logs can be decoded without fetching the ABI again.
 type Events struct {
-	Contract string `gorm:"uniqueContract:idx_addr;not null"`
-	Events  string `gorm:"not null"`
+	ID       uint64 `gorm:"primaryKey"`
+	Contract string `gorm:"uniqueIndex:idx_events_contract;not null"`
+	// JSON array of event definitions, in the ABI JSON format.
+	Events string `gorm:"type:jsonb;not null"`
+	// JSON array of event IDs (topic0), in the same order as Events.
+	Signatures string `gorm:"type:jsonb;not null"`
 }
 
-func Serialize(address map[string]abi.Event) Events {
-	return Events{"", ""}
+type abiArgument struct {
+	Name         string        `json:"name"`
+	Type         string        `json:"type"`
+	InternalType string        `json:"internalType,omitempty"`
+	Components   []abiArgument `json:"components,omitempty"`
+	Indexed      bool          `json:"indexed,omitempty"`
+}
+
+type abiEvent struct {
+	Type      string        `json:"type"`
+	Name      string        `json:"name"`
+	Inputs    []abiArgument `json:"inputs"`
+	Anonymous bool          `json:"anonymous"`
+}
+
+// marshalType returns the ABI JSON type of `t`, e.g. "tuple[2]", along
+// with the tuple components, if any.
+func marshalType(t abi.Type) (string, string, []abiArgument) {
+	switch t.T { //nolint:exhaustive
+	case abi.TupleTy:
+		components := make([]abiArgument, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			components[i] = marshalArgument(t.TupleRawNames[i], *elem, false)
+		}
+		internal := ""
+		if t.TupleRawName != "" {
+			internal = "struct " + t.TupleRawName
+		}
+		return "tuple", internal, components
+	case abi.SliceTy:
+		name, internal, components := marshalType(*t.Elem)
+		if internal != "" {
+			internal += "[]"
+		}
+		return name + "[]", internal, components
+	case abi.ArrayTy:
+		name, internal, components := marshalType(*t.Elem)
+		suffix := fmt.Sprintf("[%d]", t.Size)
+		if internal != "" {
+			internal += suffix
+		}
+		return name + suffix, internal, components
+	default:
+		return t.String(), "", nil
+	}
+}
+
+func marshalArgument(name string, t abi.Type, indexed bool) abiArgument {
+	typ, internal, components := marshalType(t)
+	return abiArgument{
+		Name:         name,
+		Type:         typ,
+		InternalType: internal,
+		Components:   components,
+		Indexed:      indexed,
+	}
+}
+
+// Serialize encodes the events of `contract`, as returned by
+// GetContractEvents, into a row of the `events` table.
+func Serialize(contract string, events map[string]abi.Event) (Events, error) {
+	var empty Events
+
+	if !ValidateAddress(contract) {
+		return empty, makeErrorHex(ErrInvalidContractAddress, contract)
+	}
+
+	// Sort by name so that overloaded events get the same names back
+	// when the JSON is parsed again.
+	names := make([]string, 0, len(events))
+	for name := range events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	xs := make([]abiEvent, len(names))
+	signatures := make([]string, len(names))
+	for i, name := range names {
+		event := events[name]
+		inputs := make([]abiArgument, len(event.Inputs))
+		for j, input := range event.Inputs {
+			inputs[j] = marshalArgument(input.Name, input.Type, input.Indexed)
+		}
+		xs[i] = abiEvent{
+			Type:      "event",
+			Name:      event.RawName,
+			Inputs:    inputs,
+			Anonymous: event.Anonymous,
+		}
+		signatures[i] = prepareHex(event.ID.Hex())
+	}
+
+	encodedEvents, err := json.Marshal(xs)
+	if err != nil {
+		return empty, fmt.Errorf("marshal events: %w", err)
+	}
+	encodedSignatures, err := json.Marshal(signatures)
+	if err != nil {
+		return empty, fmt.Errorf("marshal signatures: %w", err)
+	}
+
+	return Events{
+		ID:         0,
+		Contract:   prepareHex(contract),
+		Events:     string(encodedEvents),
+		Signatures: string(encodedSignatures),
+	}, nil
+}
+
+// Deserialize is the inverse of Serialize.
+func Deserialize(events Events) (map[string]abi.Event, error) {
+	parsed, err := abi.JSON(strings.NewReader(events.Events))
+	if err != nil {
+		return nil, fmt.Errorf("read json: %w", err)
+	}
+	return parsed.Events, nil
 }
 
 // +-----+
@@ -33,20 +171,29 @@ func Serialize(address map[string]abi.Event) Events {
 // Unique constraings:
 //   - idx_logs_abi: (address,block_number,index)
 //   - idx_logs_hi: (tx_hash,index)
+//
+// Indexes added by migrations:
+//   - idx_logs_address_topic0: (address,topic0,block_number)
+//   - idx_logs_topic1: (topic1)
+//   - idx_logs_topic2: (topic2)
+//
+// Logs do not say which chain they are on: a database holds the logs of
+// a single chain.
 type Log struct {
-	ID          uint64 `gorm:"primaryKey"`
-	Address     string `gorm:"uniqueIndex:idx_logs_abi;not null"`
-	Topic0      string ``
-	Topic1      string ``
-	Topic2      string ``
-	Topic3      string ``
-	Data        string ``
-	BlockNumber uint64 `gorm:"uniqueIndex:idx_logs_abi;not null"`
-	TxHash      string `gorm:"uniqueIndex:idx_logs_hi;not null"`
+	ID          uint64 `gorm:"primaryKey" json:"id"`
+	Address     string `gorm:"uniqueIndex:idx_logs_abi;not null" json:"address"`
+	Topic0      string `json:"topic0"`
+	Topic1      string `json:"topic1"`
+	Topic2      string `json:"topic2"`
+	Topic3      string `json:"topic3"`
+	Data        string `json:"data"`
+	BlockNumber uint64 `gorm:"uniqueIndex:idx_logs_abi;not null" json:"blockNumber"`
+	BlockHash   string `json:"blockHash"`
+	TxHash      string `gorm:"uniqueIndex:idx_logs_hi;not null" json:"txHash"`
 	// Index of the transaction in the block.
-	TxIndex uint `gorm:"not null"`
+	TxIndex uint `gorm:"not null" json:"txIndex"`
 	// Index of the log in the block.
-	Index uint `gorm:"uniqueIndex:idx_logs_abi;uniqueIndex:idx_logs_hi;not null"`
+	Index uint `gorm:"uniqueIndex:idx_logs_abi;uniqueIndex:idx_logs_hi;not null" json:"index"`
 }
 
 func FromGethLog(log types.Log) Log {
@@ -63,16 +210,21 @@ func FromGethLog(log types.Log) Log {
 		Topic3:      prepareHex(topics[3]),
 		Data:        prepareHex(common.Bytes2Hex(log.Data)),
 		BlockNumber: log.BlockNumber,
+		BlockHash:   prepareHex(log.BlockHash.Hex()),
 		TxHash:      prepareHex(log.TxHash.Hex()),
 		TxIndex:     log.TxIndex,
 		Index:       log.Index,
 	}
 }
 
+// adaptLogs converts the logs, leaving out those removed by a reorg.
 func adaptLogs(xs []types.Log) []Log {
-	ys := make([]Log, len(xs))
-	for i, x := range xs {
-		ys[i] = FromGethLog(x)
+	ys := make([]Log, 0, len(xs))
+	for _, x := range xs {
+		if x.Removed {
+			continue
+		}
+		ys = append(ys, FromGethLog(x))
 	}
 	return ys
 }
@@ -88,8 +240,131 @@ func (o Log) String() string {
 	fmt.Fprintf(&b, "\tTopic3      : %s\n", o.Topic3)
 	fmt.Fprintf(&b, "\tData        : %s\n", o.Data)
 	fmt.Fprintf(&b, "\tBlockNumber : %d\n", o.BlockNumber)
+	fmt.Fprintf(&b, "\tBlockHash   : %s\n", o.BlockHash)
 	fmt.Fprintf(&b, "\tTxHash      : %s\n", o.TxHash)
 	fmt.Fprintf(&b, "\tTxIndex     : %d\n", o.TxIndex)
 	fmt.Fprintf(&b, "\tIndex       : %d\n", o.Index)
 	return b.String()
 }
+
+// +---------------+
+// | BackfillState |
+// +---------------+
+
+// BackfillState is the checkpoint of the backfill of a contract, or of
+// a group of contracts keyed by GroupKey, on a chain.  It is updated in
+// the same transaction as each batch of logs, so resuming never rescans
+// a range, even one without logs.  Backfills restricted to some topics
+// keep their own checkpoint, since they cover only part of the
+// contract's logs.
+type BackfillState struct {
+	ChainID uint64 `gorm:"primaryKey;autoIncrement:false" json:"chainId"`
+	// Address of the contract, or key of the group.
+	Address string `gorm:"primaryKey" json:"address"`
+	// Canonical form of the topic filter; empty if unfiltered.
+	Topics string `gorm:"primaryKey;default:''" json:"topics"`
+	// Addresses of the contracts covered, as returned by GroupKey, and
+	// comma-separated.  Empty in checkpoints saved before they were
+	// recorded.
+	Addresses string `gorm:"not null;default:''" json:"addresses"`
+	// Block in which the contract was created.
+	CreationBlock uint64 `gorm:"not null" json:"creationBlock"`
+	// Last block fully scanned, and its hash at the time, used to
+	// detect reorgs.  Meaningless while Status is "new".
+	LastBlock     uint64 `gorm:"not null" json:"lastBlock"`
+	LastBlockHash string `gorm:"not null" json:"lastBlockHash"`
+	// One of the BackfillStatus constants.
+	Status    string    `gorm:"not null" json:"status"`
+	CreatedAt time.Time `gorm:"not null" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"not null" json:"updatedAt"`
+}
+
+// +---------------+
+// | BackfillShard |
+// +---------------+
+
+// BackfillShard is a range of blocks of a sharded backfill.  Shards of
+// the same contract are scanned concurrently and committed in any
+// order; the BackfillState only moves past them once all are done.
+type BackfillShard struct {
+	ID      uint64 `gorm:"primaryKey"`
+	ChainID uint64 `gorm:"index:idx_backfill_shards_cat;not null"`
+	Address string `gorm:"index:idx_backfill_shards_cat;not null"`
+	Topics  string `gorm:"index:idx_backfill_shards_cat;not null;default:''"`
+	// Range of blocks, inclusive.
+	FromBlock uint64 `gorm:"not null"`
+	ToBlock   uint64 `gorm:"not null"`
+	// First block not scanned yet.  The shard is done once NextBlock
+	// is past ToBlock.
+	NextBlock uint64    `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+func (s BackfillShard) done() bool {
+	return s.NextBlock > s.ToBlock
+}
+
+// +-------------+
+// | LogDecoding |
+// +-------------+
+
+// LogDecoding holds the decoded arguments of a Log.  Args is a JSONB
+// object keyed by argument name, with big integers as decimal strings
+// and byte strings, addresses and hashes as lowercase hex, so that rows
+// can be filtered with e.g. `(args->>'value')::numeric > 1e18`.
+type LogDecoding struct {
+	LogID     uint64 `gorm:"primaryKey"`
+	Log       *Log   `gorm:"constraint:OnDelete:CASCADE"`
+	Address   string `gorm:"index:idx_log_decodings_an;not null"`
+	Name      string `gorm:"index:idx_log_decodings_an;not null"`
+	Signature string `gorm:"not null"`
+	Args      string `gorm:"type:jsonb;not null"`
+}
+
+func FromDecodedLog(decoded DecodedLog) (LogDecoding, error) {
+	args, err := decoded.MarshalArgs()
+	if err != nil {
+		var empty LogDecoding
+		return empty, err
+	}
+	return LogDecoding{
+		LogID:     decoded.Log.ID,
+		Log:       nil,
+		Address:   decoded.Log.Address,
+		Name:      decoded.Name,
+		Signature: decoded.Signature,
+		Args:      string(args),
+	}, nil
+}
+
+// decodeLogs decodes the logs that can be decoded with `events` and
+// skips the rest.  Logs without an ID, i.e. not stored, are skipped
+// too.
+func decodeLogs(xs []Log, events map[string]abi.Event) []LogDecoding {
+	ys := make([]LogDecoding, 0, len(xs))
+	for _, x := range xs {
+		y, ok := decodeLog(x, events)
+		if ok {
+			ys = append(ys, y)
+		}
+	}
+	return ys
+}
+
+// decodeLog decodes a stored log, reporting false if it has no ID yet or
+// cannot be decoded.
+func decodeLog(x Log, events map[string]abi.Event) (LogDecoding, bool) {
+	var empty LogDecoding
+	if x.ID == 0 {
+		return empty, false
+	}
+	decoded, err := DecodeLog(x, events)
+	if err != nil {
+		return empty, false
+	}
+	y, err := FromDecodedLog(decoded)
+	if err != nil {
+		return empty, false
+	}
+	return y, true
+}
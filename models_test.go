@@ -0,0 +1,66 @@
+package core_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blocksignalio/core"
+)
+
+func TestSerialize(t *testing.T) {
+	t.Parallel()
+
+	const (
+		contract = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+		extra    = `[` +
+			`{"type":"event","name":"Transfer","anonymous":false,"inputs":[` +
+			`{"name":"id","type":"uint256","indexed":true}]},` +
+			`{"type":"event","name":"Order","anonymous":false,"inputs":[` +
+			`{"name":"maker","type":"address","indexed":true},` +
+			`{"name":"items","type":"tuple[]","internalType":"struct Item[]","components":[` +
+			`{"name":"token","type":"address"},{"name":"amounts","type":"uint256[2]"}]}]}]`
+	)
+
+	parsed, err := abi.JSON(strings.NewReader(extra))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := map[string]abi.Event{
+		"Approval": core.Approval,
+		"Transfer": core.Transfer,
+	}
+	for _, event := range parsed.Events {
+		if event.Name != "Transfer" {
+			events[event.Name] = event
+		} else {
+			events["Transfer0"] = event
+		}
+	}
+
+	row, err := core.Serialize(contract, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.ToLower(contract); row.Contract != want {
+		t.Errorf("contract: have=%s want=%s", row.Contract, want)
+	}
+
+	have, err := core.Deserialize(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have) != len(events) {
+		t.Fatalf("events: have=%d want=%d", len(have), len(events))
+	}
+	for name, want := range events {
+		if diff := cmp.Diff(have[name].String(), want.String()); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+		if have[name].ID != want.ID {
+			t.Errorf("%s: id: have=%s want=%s", name, have[name].ID, want.ID)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const envEthereumNode = "ETHEREUM_NODE"
+
+// Node is a connection to an Ethereum JSON-RPC endpoint, shared by all
+// the requests made through it.  It is safe for concurrent use.
+type Node struct {
+	client *ethclient.Client
+	rpc    *rpc.Client
+	ranges *RangeDetector
+}
+
+// NewNode wraps an existing RPC client, e.g. one connected to an
+// in-process server in tests.  Closing the Node closes the client.
+func NewNode(client *rpc.Client) *Node {
+	return &Node{
+		client: ethclient.NewClient(client),
+		rpc:    client,
+		ranges: NewRangeDetector(),
+	}
+}
+
+// NewNodeFromClient is like NewNode, but wraps an ethclient.Client.
+func NewNodeFromClient(client *ethclient.Client) *Node {
+	return &Node{
+		client: client,
+		rpc:    client.Client(),
+		ranges: NewRangeDetector(),
+	}
+}
+
+// Dial connects to the node at `url`, over HTTP or websocket depending
+// on the scheme.  Nodes dialed to the same URL share a RangeDetector.
+func Dial(ctx context.Context, url string) (*Node, error) {
+	client, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	node := NewNode(client)
+	node.ranges = sharedRangeDetector(url)
+	return node, nil
+}
+
+// DialEnv is like Dial, but reads the URL from the ETHEREUM_NODE
+// environment variable.
+func DialEnv(ctx context.Context) (*Node, error) {
+	url := os.Getenv(envEthereumNode)
+	if url == "" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsetEnvironmentVar, envEthereumNode)
+	}
+	return Dial(ctx, url)
+}
+
+func (n *Node) Close() {
+	n.client.Close()
+}
+
+// Client returns the underlying ethclient.Client.
+func (n *Node) Client() *ethclient.Client {
+	return n.client
+}
+
+// RPC returns the underlying rpc.Client.
+func (n *Node) RPC() *rpc.Client {
+	return n.rpc
+}
+
+// RangeDetector returns the detector interpreting the errors of
+// eth_getLogs.
+func (n *Node) RangeDetector() *RangeDetector {
+	return n.ranges
+}
+
+// SetRangeDetector replaces the detector interpreting the errors of
+// eth_getLogs, e.g. with one that knows more providers.  It must be
+// called before the Node is used.
+func (n *Node) SetRangeDetector(d *RangeDetector) {
+	n.ranges = d
+}
+
+// ChainID returns the ID of the chain the node follows.
+func (n *Node) ChainID(ctx context.Context) (uint64, error) {
+	id, err := n.client.ChainID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("chain id: %w", err)
+	}
+	return id.Uint64(), nil
+}
+
+// Head returns the number of the most recent block.
+func (n *Node) Head(ctx context.Context) (uint64, error) {
+	head, err := n.client.BlockNumber(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("request head: %w", err)
+	}
+	return head, nil
+}
+
+// BlockHash returns the hash of the canonical block at `number`.  The
+// node is asked for the hash rather than hashing the header locally,
+// which would be wrong on chains whose headers go-ethereum does not
+// know how to encode.
+func (n *Node) BlockHash(ctx context.Context, number uint64) (string, error) {
+	var block *struct {
+		Hash common.Hash `json:"hash"`
+	}
+	err := n.rpc.CallContext(ctx, &block, "eth_getBlockByNumber", hexutil.EncodeUint64(number), false)
+	if err != nil {
+		return "", fmt.Errorf("block by number: %w", err)
+	}
+	if block == nil {
+		return "", fmt.Errorf("block by number: %w", ethereum.NotFound)
+	}
+	return prepareHex(block.Hash.Hex()), nil
+}
+
+// Given a transaction, return the block it's included in.
+func (n *Node) TransactionBlock(ctx context.Context, tx string) (uint64, error) {
+	h := common.HexToHash(tx)
+	receipt, err := n.client.TransactionReceipt(ctx, h)
+	if err != nil {
+		return 0, fmt.Errorf("transaction by hash: %w", err)
+	}
+	blockNumber := receipt.BlockNumber.Uint64()
+	return blockNumber, nil
+}
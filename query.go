@@ -4,21 +4,20 @@ import (
 	"context"
 	"fmt"
 	"math/big"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
 // https://github.com/ethereum/EIPs/blob/master/EIPS/eip-1474.md
 const (
 	codeLimitExceeded = -32005
-	envEthereumNode   = "ETHEREUM_NODE"
+	maxTopics         = 4
 )
 
 //nolint:gochecknoglobals
@@ -101,92 +100,353 @@ func extractBound(err error) (uint64, bool) {
 	return x, true
 }
 
-func makeClient() (*ethclient.Client, error) {
-	node := os.Getenv(envEthereumNode)
-	if node == "" {
-		return nil, fmt.Errorf("%w: %s", ErrUnsetEnvironmentVar, envEthereumNode)
+// LogQuery selects the logs requested from the node.
+type LogQuery struct {
+	FromBlock uint64
+	// ToBlock is the last block, inclusive, that may be queried.  Zero
+	// means up to head.
+	ToBlock  uint64
+	Contract string
+	// Addresses lists more contracts whose logs are queried along with
+	// those of Contract, in the same requests.  Contract may be empty
+	// if Addresses is not.
+	Addresses []string
+	// Topics filters logs by topic, with the semantics of eth_getLogs:
+	// position i holds the accepted values of topic i, any of which
+	// may match, and an empty position matches anything.  At most
+	// four positions are allowed.  A nil filter matches all logs.
+	Topics [][]string
+	// Confirmations is the number of most recent blocks left out of
+	// the query because they may still be reorganized.
+	Confirmations uint64
+}
+
+// NormalizeTopics validates a topic filter and returns it in canonical
+// form: lowercase, each position sorted and free of duplicates, and
+// without trailing wildcards.
+func NormalizeTopics(topics [][]string) ([][]string, error) {
+	if len(topics) > maxTopics {
+		return nil, fmt.Errorf("%w: have=%d max=%d", ErrTooManyTopics, len(topics), maxTopics)
+	}
+	ys := make([][]string, len(topics))
+	for i, position := range topics {
+		seen := make(map[string]bool, len(position))
+		for _, topic := range position {
+			if !ValidateTopic(topic) {
+				return nil, makeErrorHex(ErrInvalidTopic, topic)
+			}
+			topic = prepareHex(topic)
+			if !seen[topic] {
+				seen[topic] = true
+				ys[i] = append(ys[i], topic)
+			}
+		}
+		sort.Strings(ys[i])
+	}
+	for len(ys) > 0 && len(ys[len(ys)-1]) == 0 {
+		ys = ys[:len(ys)-1]
 	}
+	if len(ys) == 0 {
+		return nil, nil
+	}
+	return ys, nil
+}
 
-	client, err := ethclient.Dial(node)
-	if err != nil {
-		return client, fmt.Errorf("dial: %w", err)
+// topicsKey identifies a normalized topic filter in checkpoints.  The
+// unfiltered query has the empty key.
+func topicsKey(topics [][]string) string {
+	if len(topics) == 0 {
+		return ""
 	}
+	positions := make([]string, len(topics))
+	for i, position := range topics {
+		positions[i] = strings.Join(position, "|")
+	}
+	return strings.Join(positions, ",")
+}
 
-	return client, nil
+// parseTopicsKey is the inverse of topicsKey.
+func parseTopicsKey(key string) [][]string {
+	if key == "" {
+		return nil
+	}
+	positions := strings.Split(key, ",")
+	topics := make([][]string, len(positions))
+	for i, position := range positions {
+		if position != "" {
+			topics[i] = strings.Split(position, "|")
+		}
+	}
+	return topics
 }
 
-// Given a transaction, return the block it's included in.
-func GetTransactionBlock(ctx context.Context, tx string) (uint64, error) {
-	client, err := makeClient()
-	if err != nil {
-		return 0, fmt.Errorf("makeClient: %w", err)
+// queryAddresses validates the contracts of a query and returns them
+// without duplicates.
+func queryAddresses(q LogQuery) ([]common.Address, error) {
+	contracts := q.Addresses
+	if q.Contract != "" || len(contracts) == 0 {
+		contracts = append([]string{q.Contract}, contracts...)
+	}
+	seen := make(map[common.Address]bool, len(contracts))
+	ys := make([]common.Address, 0, len(contracts))
+	for _, contract := range contracts {
+		if !ValidateAddress(contract) {
+			return nil, makeErrorHex(ErrInvalidContractAddress, contract)
+		}
+		address := common.HexToAddress(contract)
+		if !seen[address] {
+			seen[address] = true
+			ys = append(ys, address)
+		}
 	}
+	return ys, nil
+}
+
+func makeTopicFilter(topics [][]string) [][]common.Hash {
+	if len(topics) == 0 {
+		return nil
+	}
+	ys := make([][]common.Hash, len(topics))
+	for i, position := range topics {
+		for _, topic := range position {
+			ys[i] = append(ys[i], common.HexToHash(topic))
+		}
+	}
+	return ys
+}
+
+// LogPage is the result of a log query: the logs of an exact range of
+// blocks, all of which were scanned.
+type LogPage struct {
+	// FromBlock and ToBlock bound the blocks scanned, inclusive.  If
+	// no block was left to scan, the range is empty and ToBlock is
+	// FromBlock - 1.
+	FromBlock uint64
+	ToBlock   uint64
+	// Head is the most recent block reported by the node.
+	Head uint64
+	Logs []types.Log
+	// CaughtUp is set if ToBlock is the last block the query could
+	// cover, i.e. the next one would be empty.
+	CaughtUp bool
+}
+
+// Blocks returns the number of blocks scanned.
+func (p LogPage) Blocks() uint64 {
+	return p.ToBlock + 1 - p.FromBlock
+}
+
+// QueryLogs returns (next, logs, err), where `logs` is all logs in the
+// range of [fromBlock, next).  If no block was left to scan, `next` is
+// 0.  See LogQuery for the meaning of `topics`, and QueryLogPage for a
+// result that tells exactly which blocks were scanned.
+func (n *Node) QueryLogs(ctx context.Context, fromBlock uint64, contract string, topics ...[]string) (uint64, []types.Log, error) {
+	return n.Query(ctx, LogQuery{
+		FromBlock:     fromBlock,
+		ToBlock:       0,
+		Contract:      contract,
+		Addresses:     nil,
+		Topics:        topics,
+		Confirmations: 0,
+	})
+}
+
+// QueryGroupLogs is like QueryLogs, but returns the logs of all of
+// `contracts`, which share block ranges.
+func (n *Node) QueryGroupLogs(ctx context.Context, fromBlock uint64, contracts []string, topics ...[]string) (uint64, []types.Log, error) {
+	return n.Query(ctx, LogQuery{
+		FromBlock:     fromBlock,
+		ToBlock:       0,
+		Contract:      "",
+		Addresses:     contracts,
+		Topics:        topics,
+		Confirmations: 0,
+	})
+}
 
-	h := common.HexToHash(tx)
-	receipt, err := client.TransactionReceipt(ctx, h)
+// Query is like QueryLogs, but only considers blocks up to `q.ToBlock`
+// with at least `q.Confirmations` blocks on top of them.
+func (n *Node) Query(ctx context.Context, q LogQuery) (uint64, []types.Log, error) {
+	page, err := n.QueryLogPage(ctx, q)
 	if err != nil {
-		return 0, fmt.Errorf("transaction by hash: %w", err)
+		return q.FromBlock, nil, err
 	}
-	blockNumber := receipt.BlockNumber.Uint64()
-	return blockNumber, nil
+	if page.Blocks() == 0 {
+		return 0, nil, nil
+	}
+	return page.ToBlock + 1, page.Logs, nil
 }
 
-// QueryLogs returns (toBlock, logs, err), where `logs` is all logs in the
-// range of [fromBlock, toBlock).
-func QueryLogs(ctx context.Context, fromBlock uint64, contract string) (uint64, []types.Log, error) {
-	if !ValidateAddress(contract) {
-		return fromBlock, nil, makeErrorHex(ErrInvalidContractAddress, contract)
+// QueryLogPage returns the logs of as many blocks from `q.FromBlock` as
+// the node serves in one response, up to `q.ToBlock` and with at least
+// `q.Confirmations` blocks on top of them.
+func (n *Node) QueryLogPage(ctx context.Context, q LogQuery) (LogPage, error) {
+	fromBlock := q.FromBlock
+	page := LogPage{
+		FromBlock: fromBlock,
+		ToBlock:   0,
+		Head:      0,
+		Logs:      nil,
+		CaughtUp:  false,
 	}
 
-	client, err := makeClient()
+	addresses, err := queryAddresses(q)
 	if err != nil {
-		return fromBlock, nil, fmt.Errorf("makeClient: %w", err)
+		return page, err
 	}
 
-	head, err := client.BlockNumber(ctx)
+	topics, err := NormalizeTopics(q.Topics)
 	if err != nil {
-		return fromBlock, nil, fmt.Errorf("request head: %w", err)
+		return page, err
 	}
+	filter := makeTopicFilter(topics)
 
-	address := common.HexToAddress(contract)
-
-	toBlock := fromBlock + 2*powers[0]
-	for _, step := range powers {
-		if toBlock > head {
-			toBlock = head
-		}
+	head, err := n.client.BlockNumber(ctx)
+	if err != nil {
+		return page, fmt.Errorf("request head: %w", err)
+	}
+	page.Head = head
+	last := head - min(head, q.Confirmations)
+	if q.ToBlock != 0 {
+		last = min(last, q.ToBlock)
+	}
+	if fromBlock > last {
+		// fromBlock is at least 1 here, so the range is empty.
+		page.ToBlock = fromBlock - 1
+		page.CaughtUp = true
+		return page, nil
+	}
 
-		if fromBlock >= toBlock {
-			return 0, nil, nil
-		}
+	// Start with the range learned from earlier queries, then narrow
+	// it down as the node suggests, or else step down powers.
+	toBlock := fromBlock + n.ranges.start() - 1
+	steps := powers
+	narrowed := false
+	for {
+		end := min(toBlock, last)
 
 		query := ethereum.FilterQuery{
 			BlockHash: nil,
 			FromBlock: big.NewInt(int64(fromBlock)),
-			ToBlock:   big.NewInt(int64(toBlock)),
-			Addresses: []common.Address{address},
-			Topics: [][]common.Hash{
-				{},
-			},
+			ToBlock:   big.NewInt(int64(end)),
+			Addresses: addresses,
+			Topics:    filter,
 		}
 
-		logs, err := client.FilterLogs(ctx, query)
+		logs, err := n.client.FilterLogs(ctx, query)
 		if err == nil {
 			// Success!
-			return toBlock + 1, logs, nil
+			n.ranges.observe(end-fromBlock+1, narrowed, !narrowed && end == toBlock)
+			page.ToBlock = end
+			page.Logs = logs
+			page.CaughtUp = end == last
+			return page, nil
+		}
+		if ctx.Err() != nil {
+			return page, fmt.Errorf("context done: %w", ctx.Err())
 		}
+		narrowed = true
 
-		// There's an error.  See if we can extract a bounding
-		// block from it.
-		bound, ok := extractBound(err)
-		if ok { //nolint:gocritic
-			toBlock = bound
-		} else if ctx.Err() == nil {
-			toBlock = fromBlock + step
-		} else {
-			return fromBlock, nil, fmt.Errorf("context done: %w", ctx.Err())
+		// There's an error.  See if it tells how far we may go;
+		// every attempt must be narrower than the last.
+		limit, ok := n.ranges.Detect(err)
+		switch {
+		case ok && limit.ToBlock >= fromBlock && limit.ToBlock < end:
+			toBlock = limit.ToBlock
+		case ok && limit.MaxRange != 0 && fromBlock+limit.MaxRange-1 < end:
+			toBlock = fromBlock + limit.MaxRange - 1
+		default:
+			for len(steps) > 0 && fromBlock+steps[0] >= end {
+				steps = steps[1:]
+			}
+			if len(steps) == 0 {
+				return page, fmt.Errorf("filter logs: %w", err)
+			}
+			toBlock = fromBlock + steps[0]
+			steps = steps[1:]
 		}
 	}
+}
+
+// +--------+
+// | Public |
+// +--------+
+
+// The functions below dial ETHEREUM_NODE for every call.  Prefer a Node
+// when making more than one request.
+
+// Given a transaction, return the block it's included in.
+func GetTransactionBlock(ctx context.Context, tx string) (uint64, error) {
+	node, err := DialEnv(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer node.Close()
+	return node.TransactionBlock(ctx, tx)
+}
+
+// GetChainID returns the ID of the chain the node follows.
+func GetChainID(ctx context.Context) (uint64, error) {
+	node, err := DialEnv(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer node.Close()
+	return node.ChainID(ctx)
+}
+
+// GetBlockHash returns the hash of the canonical block at `number`.
+func GetBlockHash(ctx context.Context, number uint64) (string, error) {
+	node, err := DialEnv(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer node.Close()
+	return node.BlockHash(ctx, number)
+}
+
+// QueryLogs returns (next, logs, err), where `logs` is all logs in the
+// range of [fromBlock, next).  If no block was left to scan, `next` is
+// 0.  See LogQuery for the meaning of `topics`.
+func QueryLogs(ctx context.Context, fromBlock uint64, contract string, topics ...[]string) (uint64, []types.Log, error) {
+	node, err := DialEnv(ctx)
+	if err != nil {
+		return fromBlock, nil, err
+	}
+	defer node.Close()
+	return node.QueryLogs(ctx, fromBlock, contract, topics...)
+}
+
+// QueryGroupLogs is like QueryLogs, but returns the logs of all of
+// `contracts`, which share block ranges.
+func QueryGroupLogs(ctx context.Context, fromBlock uint64, contracts []string, topics ...[]string) (uint64, []types.Log, error) {
+	node, err := DialEnv(ctx)
+	if err != nil {
+		return fromBlock, nil, err
+	}
+	defer node.Close()
+	return node.QueryGroupLogs(ctx, fromBlock, contracts, topics...)
+}
 
-	panic("unreachable")
+// Query is like QueryLogs, but only considers blocks up to `q.ToBlock`
+// with at least `q.Confirmations` blocks on top of them.
+func Query(ctx context.Context, q LogQuery) (uint64, []types.Log, error) {
+	node, err := DialEnv(ctx)
+	if err != nil {
+		return q.FromBlock, nil, err
+	}
+	defer node.Close()
+	return node.Query(ctx, q)
+}
+
+// QueryLogPage returns the logs of as many blocks from `q.FromBlock` as
+// the node serves in one response.  See Node.QueryLogPage.
+func QueryLogPage(ctx context.Context, q LogQuery) (LogPage, error) {
+	node, err := DialEnv(ctx)
+	if err != nil {
+		var empty LogPage
+		return empty, err
+	}
+	defer node.Close()
+	return node.QueryLogPage(ctx, q)
 }
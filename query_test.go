@@ -2,9 +2,15 @@ package core_test
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/coretest"
 )
 
 func TestQuery(t *testing.T) {
@@ -12,13 +18,25 @@ func TestQuery(t *testing.T) {
 
 	const (
 		fromBlock = 17899693
-		weth      = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+		perBlock  = 80
 
-		wantBlocks = 130
-		wantLogs   = 9976
+		wantBlocks = 125
+		wantLogs   = 10000
 	)
 
-	toBlock, logs, err := core.QueryLogs(context.Background(), fromBlock, weth)
+	// Busy enough for the node to refuse the first range.
+	chain := coretest.NewChain(1, fromBlock+1000)
+	chain.SetMaxResults(10000)
+	var xs []types.Log
+	for block := uint64(fromBlock); block <= chain.Head(); block++ {
+		for i := range uint(perBlock) {
+			xs = append(xs, coretest.MakeLog(coretest.WETH, block, i))
+		}
+	}
+	chain.AddLogs(xs...)
+	node := chain.Node(t)
+
+	toBlock, logs, err := node.QueryLogs(context.Background(), fromBlock, coretest.WETH)
 	if err != nil {
 		t.Error(err)
 	}
@@ -31,3 +49,89 @@ func TestQuery(t *testing.T) {
 		t.Errorf("logs: have=%d want=%d", haveLogs, wantLogs)
 	}
 }
+
+func TestQueryLogPage(t *testing.T) {
+	t.Parallel()
+
+	const (
+		head     = 1000
+		maxRange = 100
+	)
+
+	chain := coretest.NewChain(1, head)
+	chain.SetMaxRange(maxRange)
+	chain.AddLogs(
+		coretest.MakeLog(coretest.WETH, 10, 0),
+		coretest.MakeLog(coretest.WETH, 99, 0),
+		coretest.MakeLog(coretest.WETH, 100, 0),
+		coretest.MakeLog(coretest.WETH, head, 0),
+	)
+	node := chain.Node(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		fromBlock     uint64
+		confirmations uint64
+		want          core.LogPage
+		logs          int
+	}{
+		{"limited", 0, 0, core.LogPage{FromBlock: 0, ToBlock: 99, Head: head, CaughtUp: false}, 2},
+		{"head", 950, 0, core.LogPage{FromBlock: 950, ToBlock: head, Head: head, CaughtUp: true}, 1},
+		{"confirmations", 950, 10, core.LogPage{FromBlock: 950, ToBlock: head - 10, Head: head, CaughtUp: true}, 0},
+		{"past head", head + 1, 0, core.LogPage{FromBlock: head + 1, ToBlock: head, Head: head, CaughtUp: true}, 0},
+	}
+	for _, test := range tests {
+		page, err := node.QueryLogPage(ctx, core.LogQuery{
+			FromBlock:     test.fromBlock,
+			ToBlock:       0,
+			Contract:      coretest.WETH,
+			Addresses:     nil,
+			Topics:        nil,
+			Confirmations: test.confirmations,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if have := len(page.Logs); have != test.logs {
+			t.Errorf("%s: logs: have=%d want=%d", test.name, have, test.logs)
+		}
+		page.Logs = nil
+		if diff := cmp.Diff(test.want, page); diff != "" {
+			t.Errorf("%s: page (-want +have):\n%s", test.name, diff)
+		}
+	}
+
+	// The limit stated by the node is remembered.
+	if have := node.RangeDetector().MaxRange(); have != maxRange {
+		t.Errorf("max range: have=%d want=%d", have, maxRange)
+	}
+}
+
+func TestNormalizeTopics(t *testing.T) {
+	t.Parallel()
+
+	const (
+		transfer = "0xDDF252AD1BE2C89B69C2B068FC378DAA952BA7F163C4A11628F55A4DF523B3EF"
+		approval = "0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"
+	)
+
+	have, err := core.NormalizeTopics([][]string{{transfer, approval, transfer}, {}, {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{approval, strings.ToLower(transfer)}}
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("topics (-want +have):\n%s", diff)
+	}
+
+	_, err = core.NormalizeTopics([][]string{{"0x1234"}})
+	if !errors.Is(err, core.ErrInvalidTopic) {
+		t.Errorf("short topic: have=%v want=%v", err, core.ErrInvalidTopic)
+	}
+
+	_, err = core.NormalizeTopics(make([][]string, 5))
+	if !errors.Is(err, core.ErrTooManyTopics) {
+		t.Errorf("five positions: have=%v want=%v", err, core.ErrTooManyTopics)
+	}
+}
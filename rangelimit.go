@@ -0,0 +1,233 @@
+package core
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// defaultSpan is the number of blocks first asked for by an endpoint
+// that has not rejected a range yet: twice the top of powers, plus one.
+const defaultSpan = 2*0x200000 + 1
+
+// RangeLimit is what an error returned by eth_getLogs tells about the
+// ranges of blocks the node accepts.  A zero RangeLimit only says that
+// the range was rejected.
+type RangeLimit struct {
+	// ToBlock, if not zero, ends a range, starting at the queried
+	// block, that the node suggests.
+	ToBlock uint64
+	// MaxRange, if not zero, is the number of blocks a query may span.
+	MaxRange uint64
+}
+
+// RangeLimitParser recognizes the range errors of some providers and
+// reports false for any other error.
+type RangeLimitParser func(err error) (RangeLimit, bool)
+
+// DefaultRangeLimitParsers returns parsers for the EIP-1474 error and
+// for the messages of the most common providers.
+func DefaultRangeLimitParsers() []RangeLimitParser {
+	return []RangeLimitParser{
+		parseLimitExceeded,
+		parseSuggestedRange,
+		parseMaxRange,
+		parseRangeTooWide,
+	}
+}
+
+// parseLimitExceeded understands the -32005 error, whose data holds the
+// last block that may be queried, e.g. {"from": "0x1", "to": "0x2"}.
+func parseLimitExceeded(err error) (RangeLimit, bool) {
+	to, ok := extractBound(err)
+	if !ok {
+		return RangeLimit{ToBlock: 0, MaxRange: 0}, false
+	}
+	return RangeLimit{ToBlock: to, MaxRange: 0}, true
+}
+
+//nolint:gochecknoglobals
+var suggestedRange = regexp.MustCompile(`(?i)range.*\[\s*(0x[0-9a-f]+)\s*,\s*(0x[0-9a-f]+)\s*\]`)
+
+// parseSuggestedRange understands messages suggesting a range, e.g.
+//
+//	query returned more than 10000 results. Try with this block range [0x1, 0x2].
+//	Log response size exceeded. [...] this block range should work: [0x1, 0x2]
+func parseSuggestedRange(err error) (RangeLimit, bool) {
+	m := suggestedRange.FindStringSubmatch(err.Error())
+	if m == nil {
+		return RangeLimit{ToBlock: 0, MaxRange: 0}, false
+	}
+	to, perr := strconv.ParseUint(m[2][2:], 16, 64)
+	if perr != nil {
+		return RangeLimit{ToBlock: 0, MaxRange: 0}, false
+	}
+	return RangeLimit{ToBlock: to, MaxRange: 0}, true
+}
+
+//nolint:gochecknoglobals
+var maxRange = regexp.MustCompile(
+	`(?i)(?:maximum block range|max block range|blocks distance|limited to an?|up to an?)\s*:?\s*([0-9][0-9,]*)(k?)\b`,
+)
+
+// parseMaxRange understands messages stating the largest range, e.g.
+//
+//	exceed maximum block range: 5000
+//	eth_getLogs is limited to a 10,000 range
+//	You can make eth_getLogs requests with up to a 2K block range
+func parseMaxRange(err error) (RangeLimit, bool) {
+	m := maxRange.FindStringSubmatch(err.Error())
+	if m == nil {
+		return RangeLimit{ToBlock: 0, MaxRange: 0}, false
+	}
+	n, perr := strconv.ParseUint(strings.ReplaceAll(m[1], ",", ""), 10, 64)
+	if perr != nil || n == 0 {
+		return RangeLimit{ToBlock: 0, MaxRange: 0}, false
+	}
+	if m[2] != "" {
+		n *= 1000
+	}
+	return RangeLimit{ToBlock: 0, MaxRange: n}, true
+}
+
+//nolint:gochecknoglobals
+var rangeTooWide = []string{
+	"block range is too wide",
+	"block range too large",
+	"range limit exceeded",
+	"more than 10000 results",
+}
+
+// parseRangeTooWide recognizes range errors that give no hint, e.g.
+// "block range is too wide", and any other -32005 error.
+func parseRangeTooWide(err error) (RangeLimit, bool) {
+	var empty RangeLimit
+	msg := strings.ToLower(err.Error())
+	for _, s := range rangeTooWide {
+		if strings.Contains(msg, s) {
+			return empty, true
+		}
+	}
+	a, ok := err.(rpc.Error) //nolint:errorlint
+	return empty, ok && a.ErrorCode() == codeLimitExceeded
+}
+
+// RangeDetector interprets the errors an endpoint returns for ranges it
+// rejects, and learns from them how many blocks to ask for, so that
+// later queries do not start with ranges bound to fail.  A Node has one
+// per endpoint; it is safe for concurrent use.
+type RangeDetector struct {
+	parsers []RangeLimitParser
+
+	mu sync.Mutex
+	// maxRange is the smallest limit stated by the endpoint, if any.
+	maxRange uint64
+	// span is the number of blocks to ask for first, if known.
+	span uint64
+}
+
+// NewRangeDetector returns a detector trying `parsers` in order.  With
+// no parsers, DefaultRangeLimitParsers are used.
+func NewRangeDetector(parsers ...RangeLimitParser) *RangeDetector {
+	if len(parsers) == 0 {
+		parsers = DefaultRangeLimitParsers()
+	}
+	return &RangeDetector{
+		parsers:  parsers,
+		mu:       sync.Mutex{},
+		maxRange: 0,
+		span:     0,
+	}
+}
+
+// Detect returns what `err` tells about the ranges the endpoint accepts,
+// or false if it is not a known range error.  The hints of all parsers
+// that recognize the error are combined, and a stated limit is
+// remembered.
+func (d *RangeDetector) Detect(err error) (RangeLimit, bool) {
+	var (
+		limit RangeLimit
+		known bool
+	)
+	for _, parse := range d.parsers {
+		x, ok := parse(err)
+		if !ok {
+			continue
+		}
+		known = true
+		if limit.ToBlock == 0 {
+			limit.ToBlock = x.ToBlock
+		}
+		if limit.MaxRange == 0 || (x.MaxRange != 0 && x.MaxRange < limit.MaxRange) {
+			limit.MaxRange = x.MaxRange
+		}
+	}
+	if limit.MaxRange != 0 {
+		d.mu.Lock()
+		if d.maxRange == 0 || limit.MaxRange < d.maxRange {
+			d.maxRange = limit.MaxRange
+		}
+		d.mu.Unlock()
+	}
+	return limit, known
+}
+
+// MaxRange returns the limit stated by the endpoint, or 0 if none was.
+func (d *RangeDetector) MaxRange() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.maxRange
+}
+
+// start returns the number of blocks to ask for first.
+func (d *RangeDetector) start() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	span := d.span
+	if span == 0 {
+		span = defaultSpan
+	}
+	if d.maxRange != 0 {
+		span = min(span, d.maxRange)
+	}
+	return span
+}
+
+// observe records a query of `span` blocks that succeeded.  If earlier
+// attempts were rejected, the next query starts with `span` blocks.  If
+// the first attempt succeeded with all the blocks it was allowed, the
+// next one starts with twice as many, since limits on the number of
+// results depend on how busy the blocks are.
+func (d *RangeDetector) observe(span uint64, narrowed, full bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case narrowed:
+		d.span = span
+	case full && d.span != 0:
+		d.span = min(2*d.span, defaultSpan)
+	}
+}
+
+//nolint:gochecknoglobals
+var (
+	detectorsMu sync.Mutex
+	detectors   = make(map[string]*RangeDetector)
+)
+
+// sharedRangeDetector returns the detector of the endpoint at `url`, so
+// that what is learned outlives a Node.
+func sharedRangeDetector(url string) *RangeDetector {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+
+	d, ok := detectors[url]
+	if !ok {
+		d = NewRangeDetector()
+		detectors[url] = d
+	}
+	return d
+}
@@ -0,0 +1,132 @@
+package core_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/blocksignalio/core"
+)
+
+type rpcError struct {
+	code int
+	msg  string
+	data any
+}
+
+func (e rpcError) Error() string  { return e.msg }
+func (e rpcError) ErrorCode() int { return e.code }
+func (e rpcError) ErrorData() any { return e.data }
+
+func TestRangeDetector(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		ok   bool
+		want core.RangeLimit
+	}{
+		{
+			name: "eip-1474",
+			err:  rpcError{code: -32005, msg: "limit exceeded", data: map[string]any{"from": "0x10", "to": "0x20"}},
+			ok:   true,
+			want: core.RangeLimit{ToBlock: 0x20, MaxRange: 0},
+		},
+		{
+			// Both the data and the message tell something.
+			name: "eip-1474 with limit",
+			err:  rpcError{code: -32005, msg: "exceed maximum block range: 100", data: map[string]any{"from": "0x10", "to": "0x73"}},
+			ok:   true,
+			want: core.RangeLimit{ToBlock: 0x73, MaxRange: 100},
+		},
+		{
+			name: "infura",
+			err:  rpcError{code: -32005, msg: "query returned more than 10000 results. Try with this block range [0x10, 0x1F].", data: nil},
+			ok:   true,
+			want: core.RangeLimit{ToBlock: 0x1f, MaxRange: 0},
+		},
+		{
+			name: "alchemy",
+			err: rpcError{
+				code: -32602,
+				msg: "Log response size exceeded. You can make eth_getLogs requests with up to a 2K block range and no limit " +
+					"on the response size, or you can request any block range with a cap of 10K logs in the response. Based " +
+					"on your parameters and the response size limit, this block range should work: [0x10, 0x30]",
+				data: nil,
+			},
+			ok:   true,
+			want: core.RangeLimit{ToBlock: 0x30, MaxRange: 2000},
+		},
+		{
+			name: "bsc",
+			err:  errors.New("exceed maximum block range: 5000"),
+			ok:   true,
+			want: core.RangeLimit{ToBlock: 0, MaxRange: 5000},
+		},
+		{
+			name: "quicknode",
+			err:  errors.New("eth_getLogs is limited to a 10,000 range"),
+			ok:   true,
+			want: core.RangeLimit{ToBlock: 0, MaxRange: 10000},
+		},
+		{
+			name: "too wide",
+			err:  rpcError{code: -32000, msg: "block range is too wide", data: nil},
+			ok:   true,
+			want: core.RangeLimit{ToBlock: 0, MaxRange: 0},
+		},
+		{
+			name: "unrelated",
+			err:  errors.New("connection refused"),
+			ok:   false,
+			want: core.RangeLimit{ToBlock: 0, MaxRange: 0},
+		},
+	}
+
+	for _, test := range tests {
+		d := core.NewRangeDetector()
+		have, ok := d.Detect(test.err)
+		if ok != test.ok || have != test.want {
+			t.Errorf("%s: have=%+v,%t want=%+v,%t", test.name, have, ok, test.want, test.ok)
+		}
+		if maxRange := d.MaxRange(); maxRange != test.want.MaxRange {
+			t.Errorf("%s: max range: have=%d want=%d", test.name, maxRange, test.want.MaxRange)
+		}
+	}
+}
+
+func TestRangeDetectorParsers(t *testing.T) {
+	t.Parallel()
+
+	parser := func(limit core.RangeLimit) core.RangeLimitParser {
+		return func(error) (core.RangeLimit, bool) { return limit, true }
+	}
+	ignore := func(error) (core.RangeLimit, bool) { return core.RangeLimit{ToBlock: 0, MaxRange: 0}, false }
+
+	// The first suggested range wins, along with the smallest limit
+	// stated by any parser.
+	d := core.NewRangeDetector(
+		ignore,
+		parser(core.RangeLimit{ToBlock: 0, MaxRange: 50}),
+		parser(core.RangeLimit{ToBlock: 5, MaxRange: 0}),
+		parser(core.RangeLimit{ToBlock: 9, MaxRange: 20}),
+	)
+	have, ok := d.Detect(errors.New("rejected"))
+	if want := (core.RangeLimit{ToBlock: 5, MaxRange: 20}); !ok || have != want {
+		t.Errorf("detect: have=%+v,%t want=%+v,true", have, ok, want)
+	}
+
+	d = core.NewRangeDetector(ignore)
+	if _, ok := d.Detect(errors.New("rejected")); ok {
+		t.Errorf("unknown error: have=true want=false")
+	}
+
+	// A larger limit stated later does not replace the smaller one.
+	d = core.NewRangeDetector()
+	for _, msg := range []string{"exceed maximum block range: 300", "exceed maximum block range: 100", "exceed maximum block range: 200"} {
+		_, _ = d.Detect(errors.New(msg))
+	}
+	if have := d.MaxRange(); have != 100 {
+		t.Errorf("max range: have=%d want=100", have)
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket: it holds at most `burst` tokens and is
+// refilled at `rate` tokens per second.  Each request takes one token.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		mu:     sync.Mutex{},
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token if one is available, otherwise it returns how
+// long to wait before trying again.
+func (l *rateLimiter) reserve() (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+
+	missing := (1 - l.tokens) / l.rate
+	return time.Duration(missing * float64(time.Second)), false
+}
+
+// Wait blocks until a token is available or the context is done.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	for {
+		delay, ok := l.reserve()
+		if ok {
+			return nil
+		}
+		err := sleep(ctx, delay)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("context done: %w", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// lower slows the bucket down to `rate`, if that is lower, shrinking the
+// burst to match.
+func (l *rateLimiter) lower(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rate >= l.rate {
+		return
+	}
+	l.rate = rate
+	l.burst = float64(max(1, int(rate)))
+	l.tokens = min(l.tokens, l.burst)
+}
+
+// Limits are enforced by the explorer per API key, so clients sharing a
+// key must also share a bucket.
+//
+//nolint:gochecknoglobals
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*rateLimiter)
+)
+
+// sharedRateLimiter returns the bucket of the endpoint and key.  Clients
+// asking for different rates get the lowest of them, since the explorer
+// only knows the key.
+func sharedRateLimiter(baseURL, apikey string, rate float64) *rateLimiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	key := baseURL + "|" + apikey
+	l, ok := limiters[key]
+	if !ok {
+		l = newRateLimiter(rate, max(1, int(rate)))
+		limiters[key] = l
+	}
+	l.lower(rate)
+	return l
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"gorm.io/gorm"
+)
+
+func loadShards(ctx context.Context, db *gorm.DB, state BackfillState) ([]BackfillShard, error) {
+	var xs []BackfillShard
+	result := db.WithContext(ctx).
+		Where("chain_id = ? AND address = ? AND topics = ?", state.ChainID, state.Address, state.Topics).
+		Order("from_block").
+		Find(&xs)
+	if result.Error != nil {
+		return nil, fmt.Errorf("find shards: %w", result.Error)
+	}
+	return xs, nil
+}
+
+// planShards splits the blocks between the checkpoint and the last
+// confirmed block into `n` shards of about the same size and stores
+// them.
+func (e *backfillEnv) planShards(ctx context.Context, state BackfillState, n int) ([]BackfillShard, error) {
+	head, err := e.head(ctx)
+	if err != nil {
+		return nil, err
+	}
+	end := head - min(head, e.opts.Confirmations)
+	start := state.resumeBlock()
+	if start > end {
+		return nil, nil
+	}
+
+	total := end - start + 1
+	count := min(uint64(n), total)
+	size := total / count
+	xs := make([]BackfillShard, 0, count)
+	for i := range count {
+		from := start + i*size
+		to := from + size - 1
+		if i == count-1 {
+			to = end
+		}
+		xs = append(xs, BackfillShard{ //nolint:exhaustruct
+			ChainID:   state.ChainID,
+			Address:   state.Address,
+			Topics:    state.Topics,
+			FromBlock: from,
+			ToBlock:   to,
+			NextBlock: from,
+		})
+	}
+
+	result := e.db.WithContext(ctx).Create(&xs)
+	if result.Error != nil {
+		return nil, fmt.Errorf("create shards: %w", result.Error)
+	}
+	return xs, nil
+}
+
+// runShard scans a shard to its end, committing each batch of logs
+// along with the shard's new position.
+func (e *backfillEnv) runShard(ctx context.Context, shard BackfillShard, addresses []string, events eventSet) error {
+	for !shard.done() {
+		page, err := e.queryLogPage(ctx, LogQuery{
+			FromBlock:     shard.NextBlock,
+			ToBlock:       shard.ToBlock,
+			Contract:      "",
+			Addresses:     addresses,
+			Topics:        parseTopicsKey(shard.Topics),
+			Confirmations: 0,
+		})
+		if err != nil {
+			return err
+		}
+		if page.Blocks() == 0 {
+			// The chain is shorter than when the shard was planned.
+			return fmt.Errorf("%w: block %d, head %d", ethereum.NotFound, shard.NextBlock, page.Head)
+		}
+
+		next := shard
+		next.NextBlock = page.ToBlock + 1
+		ys := adaptLogs(page.Logs)
+		err = e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			err := storeLogs(tx, ys)
+			if err != nil {
+				return err
+			}
+			err = storeDecodings(tx, events.decode(ys))
+			if err != nil {
+				return err
+			}
+			result := tx.Save(&next)
+			if result.Error != nil {
+				return fmt.Errorf("save shard: %w", result.Error)
+			}
+			return nil
+		})
+		if err != nil {
+			return &BlockRangeError{
+				Contract:  shard.Address,
+				FromBlock: page.FromBlock,
+				ToBlock:   page.ToBlock,
+				Err:       err,
+			}
+		}
+		shard = next
+
+		e.report(BackfillProgress{
+			Contract:  shard.Address,
+			FromBlock: page.FromBlock,
+			ToBlock:   page.ToBlock,
+			Logs:      len(ys),
+			Done:      false,
+			Err:       nil,
+		})
+	}
+	return nil
+}
+
+// runShards completes the shards with `workers` goroutines.  Once all
+// are done, they are replaced by moving the checkpoint to the end of
+// the last one.
+func (e *backfillEnv) runShards(
+	ctx context.Context,
+	state BackfillState,
+	shards []BackfillShard,
+	addresses []string,
+	events eventSet,
+	workers int,
+) (BackfillState, error) {
+	var (
+		end  uint64
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+
+	jobs := make(chan BackfillShard)
+	for range max(1, workers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range jobs {
+				err := e.runShard(ctx, shard, addresses, events)
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, shard := range shards {
+		end = max(end, shard.ToBlock)
+		if !shard.done() {
+			jobs <- shard
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return state, errors.Join(errs...)
+	}
+
+	hash, err := e.blockHash(ctx, end)
+	if err != nil {
+		return state, err
+	}
+	next := state
+	next.LastBlock = end
+	next.LastBlockHash = hash
+	next.Status = BackfillStatusRunning
+	err = e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Where("chain_id = ? AND address = ? AND topics = ?", state.ChainID, state.Address, state.Topics).
+			Delete(&BackfillShard{}) //nolint:exhaustruct
+		if result.Error != nil {
+			return fmt.Errorf("delete shards: %w", result.Error)
+		}
+		return saveState(ctx, tx, next)
+	})
+	if err != nil {
+		return state, err
+	}
+	return next, nil
+}
@@ -0,0 +1,109 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/coretest"
+)
+
+func TestBackfillShards(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t, 20)
+	f.chain.AddLogs(transfer(2, 0), transfer(8, 0), transfer(14, 0), transfer(19, 0))
+	f.backfiller.Options.Shards = 3
+	f.backfiller.Options.ShardWorkers = 2
+
+	// The shard holding +8 fails; the others complete.
+	f.failDecoding(t, coretest.WETHCreationBlock+8)
+	results := f.backfiller.Run(context.Background(), []string{coretest.WETH})
+	var rangeErr *core.BlockRangeError
+	if !errors.As(results[0].Err, &rangeErr) {
+		t.Fatalf("error: have=%v want a BlockRangeError", results[0].Err)
+	}
+
+	// The shards cover every block once, and are kept for the next run.
+	var shards []core.BackfillShard
+	err := f.db.Order("from_block").Find(&shards).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 3 {
+		t.Fatalf("shards: have=%d want=3", len(shards))
+	}
+	next := uint64(coretest.WETHCreationBlock)
+	var failed core.BackfillShard
+	for _, shard := range shards {
+		if shard.FromBlock != next {
+			t.Errorf("shard: have=[%d, %d] want it to start at %d", shard.FromBlock, shard.ToBlock, next)
+		}
+		next = shard.ToBlock + 1
+		if shard.NextBlock <= shard.ToBlock {
+			failed = shard
+		}
+	}
+	if have, want := next-1, f.chain.Head(); have != want {
+		t.Errorf("last shard end: have=%d want=%d", have, want)
+	}
+	if failed.FromBlock != shards[1].FromBlock {
+		t.Errorf("failed shard: have=[%d, %d] want=[%d, %d]",
+			failed.FromBlock, failed.ToBlock, shards[1].FromBlock, shards[1].ToBlock)
+	}
+
+	// The next run completes the failed shard only.
+	f.heal(t)
+	scanned := len(f.chain.Queries())
+	f.run(t, coretest.WETH)
+	for _, q := range f.chain.Queries()[scanned:] {
+		if q[0] < failed.NextBlock || (q[0] <= shards[2].ToBlock && q[1] > failed.ToBlock) {
+			t.Errorf("rescanned: [%d, %d]", q[0], q[1])
+		}
+	}
+	if diff := cmp.Diff([]string{"2/0", "8/0", "14/0", "19/0"}, f.logs(t)); diff != "" {
+		t.Errorf("logs (-want +have):\n%s", diff)
+	}
+	if have := f.count(t, &core.BackfillShard{}); have != 0 { //nolint:exhaustruct
+		t.Errorf("shards left: have=%d want=0", have)
+	}
+	if have, want := f.state(t, wethKey).LastBlock, f.chain.Head(); have != want {
+		t.Errorf("checkpoint: have=%d want=%d", have, want)
+	}
+}
+
+func TestBackfillShardsReorg(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t, 10)
+	f.chain.AddLogs(transfer(2, 0), transfer(8, 0))
+	f.run(t, coretest.WETH)
+
+	// Shards [+11, +16] and [+23, +30] complete; [+17, +22] fails.
+	f.chain.AddLogs(transfer(14, 0), transfer(22, 0), transfer(28, 0))
+	f.chain.SetHead(coretest.WETHCreationBlock + 30)
+	f.backfiller.Options.Shards = 3
+	f.failDecoding(t, coretest.WETHCreationBlock+22)
+	results := f.backfiller.Run(context.Background(), []string{coretest.WETH})
+	if results[0].Err == nil {
+		t.Fatal("error: have=nil want a BlockRangeError")
+	}
+	f.heal(t)
+
+	// The reorg reaches below the checkpoint, so every block the shards
+	// scanned is scanned again.
+	f.chain.Reorg(coretest.WETHCreationBlock+9, transfer(9, 0), transfer(15, 0), transfer(22, 0), transfer(27, 0))
+	f.run(t, coretest.WETH)
+	if diff := cmp.Diff([]string{"2/0", "8/0", "9/0", "15/0", "22/0", "27/0"}, f.logs(t)); diff != "" {
+		t.Errorf("logs (-want +have):\n%s", diff)
+	}
+	if have := f.count(t, &core.BackfillShard{}); have != 0 { //nolint:exhaustruct
+		t.Errorf("shards left: have=%d want=0", have)
+	}
+	if have, want := f.state(t, wethKey).LastBlock, f.chain.Head(); have != want {
+		t.Errorf("checkpoint: have=%d want=%d", have, want)
+	}
+}
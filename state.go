@@ -0,0 +1,353 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Values of BackfillState.Status.
+const (
+	// BackfillStatusNew means that nothing has been scanned yet.
+	BackfillStatusNew = "new"
+	// BackfillStatusRunning means that a backfill is in progress or was
+	// interrupted.
+	BackfillStatusRunning = "running"
+	// BackfillStatusSynced means that the last backfill caught up with
+	// the chain.
+	BackfillStatusSynced = "synced"
+	// BackfillStatusFailed means that the last backfill stopped on an
+	// error.
+	BackfillStatusFailed = "failed"
+)
+
+// members returns the addresses of the contracts covered.
+func (s BackfillState) members() []string {
+	if s.Addresses == "" {
+		return []string{s.Address}
+	}
+	return strings.Split(s.Addresses, ",")
+}
+
+// resumeBlock returns the first block left to scan.  A backfill that
+// failed before its first batch has scanned nothing past its creation
+// block.
+func (s BackfillState) resumeBlock() uint64 {
+	if s.Status == BackfillStatusNew {
+		return s.CreationBlock
+	}
+	return max(s.CreationBlock, s.LastBlock+1)
+}
+
+func loadState(ctx context.Context, db *gorm.DB, chainID uint64, key, topics string) (BackfillState, bool, error) {
+	var xs []BackfillState
+	result := db.WithContext(ctx).
+		Where("chain_id = ? AND address = ? AND topics = ?", chainID, key, topics).
+		Limit(1).
+		Find(&xs)
+	if result.Error != nil {
+		var empty BackfillState
+		return empty, false, fmt.Errorf("find state: %w", result.Error)
+	}
+	if len(xs) == 0 {
+		var empty BackfillState
+		return empty, false, nil
+	}
+	return xs[0], true, nil
+}
+
+// checkChain makes sure that `db` holds no checkpoint of a chain other
+// than `chainID`.  Logs do not say which chain they are on, so those of
+// two chains would be mixed up, and a reorg on one would delete logs of
+// the other.
+func checkChain(ctx context.Context, db *gorm.DB, chainID uint64) error {
+	var xs []BackfillState
+	result := db.WithContext(ctx).
+		Select("chain_id").
+		Where("chain_id <> ?", chainID).
+		Limit(1).
+		Find(&xs)
+	if result.Error != nil {
+		return fmt.Errorf("find states: %w", result.Error)
+	}
+	if len(xs) > 0 {
+		return fmt.Errorf("%w: have=%d want=%d", ErrChainMismatch, chainID, xs[0].ChainID)
+	}
+	return nil
+}
+
+// LoadBackfillStates returns the checkpoints of the backfills of
+// `contract` on every chain and under every topic filter.  Checkpoints
+// of groups are keyed by GroupKey instead.
+func LoadBackfillStates(ctx context.Context, db *gorm.DB, contract string) ([]BackfillState, error) {
+	if !ValidateAddress(contract) {
+		return nil, makeErrorHex(ErrInvalidContractAddress, contract)
+	}
+	var xs []BackfillState
+	result := db.WithContext(ctx).
+		Where("address = ?", prepareHex(contract)).
+		Order("chain_id, topics").
+		Find(&xs)
+	if result.Error != nil {
+		return nil, fmt.Errorf("find states: %w", result.Error)
+	}
+	return xs, nil
+}
+
+func saveState(ctx context.Context, db *gorm.DB, state BackfillState) error {
+	result := db.WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}). //nolint:exhaustruct
+		Create(&state)
+	if result.Error != nil {
+		return fmt.Errorf("save state: %w", result.Error)
+	}
+	return nil
+}
+
+// initState creates the checkpoint of a group of contracts backfilled
+// for the first time.  Unfiltered deployments of a single contract that
+// predate checkpoints resume after its last stored log; otherwise the
+// creation block of the oldest contract is looked up, which costs
+// Etherscan and RPC calls, but only once.
+func (e *backfillEnv) initState(ctx context.Context, chainID uint64, key string, addresses []string, topics string) (BackfillState, error) {
+	db := e.db
+
+	state := BackfillState{
+		ChainID:       chainID,
+		Address:       key,
+		Topics:        topics,
+		Addresses:     strings.Join(addresses, ","),
+		CreationBlock: 0,
+		LastBlock:     0,
+		LastBlockHash: "",
+		Status:        BackfillStatusNew,
+		CreatedAt:     time.Time{},
+		UpdatedAt:     time.Time{},
+	}
+
+	// Stored logs say nothing about what a filtered backfill, or that
+	// of a group, has covered.
+	var last []Log
+	if topics == "" && len(addresses) == 1 {
+		result := db.WithContext(ctx).
+			Select("block_number", "block_hash").
+			Where("address = ?", state.Address).
+			Order("block_number desc, index desc").
+			Limit(1).
+			Find(&last)
+		if result.Error != nil {
+			return state, fmt.Errorf("find: %w", result.Error)
+		}
+	}
+
+	var err error
+	state.CreationBlock, err = e.creationBlock(ctx, addresses)
+	if err != nil {
+		return state, err
+	}
+
+	if len(last) > 0 {
+		state.LastBlock = last[0].BlockNumber
+		state.LastBlockHash = last[0].BlockHash
+		state.Status = BackfillStatusRunning
+	}
+
+	err = saveState(ctx, db, state)
+	if err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+// creationBlock returns the block in which the oldest of `addresses` was
+// created.
+func (e *backfillEnv) creationBlock(ctx context.Context, addresses []string) (uint64, error) {
+	// Etherscan looks up at most this many contracts per call.
+	const batchSize = 5
+
+	var block uint64
+	for i := 0; i < len(addresses); i += batchSize {
+		xs, err := e.etherscan.GetContractCreation(ctx, addresses[i:min(i+batchSize, len(addresses))])
+		if err != nil {
+			return 0, err
+		}
+		for j, x := range xs {
+			number, err := e.transactionBlock(ctx, x.TxHash)
+			if err != nil {
+				return 0, err
+			}
+			if i+j == 0 || number < block {
+				block = number
+			}
+		}
+	}
+	return block, nil
+}
+
+// findCommonAncestor walks back through the blocks of the stored logs
+// of `addresses`, up to `block`, and returns the most recent one that is
+// still canonical, or 0 if there is none.  Logs stored before block
+// hashes were recorded cannot be checked and are trusted.
+func (e *backfillEnv) findCommonAncestor(ctx context.Context, addresses []string, block uint64) (uint64, error) {
+	const batchSize = 256
+
+	for {
+		var xs []Log
+		result := e.db.WithContext(ctx).
+			Distinct("block_number", "block_hash").
+			Where("address IN ? AND block_number <= ?", addresses, block).
+			Order("block_number desc").
+			Limit(batchSize).
+			Find(&xs)
+		if result.Error != nil {
+			return 0, fmt.Errorf("find: %w", result.Error)
+		}
+		if len(xs) == 0 {
+			return 0, nil
+		}
+
+		for _, x := range xs {
+			if x.BlockHash == "" {
+				return x.BlockNumber, nil
+			}
+			canonical, err := e.blockHash(ctx, x.BlockNumber)
+			if err != nil {
+				return 0, err
+			}
+			if canonical == x.BlockHash {
+				return x.BlockNumber, nil
+			}
+		}
+
+		last := xs[len(xs)-1].BlockNumber
+		if last == 0 {
+			return 0, nil
+		}
+		block = last - 1
+	}
+}
+
+// checkReorg compares the checkpoint against the canonical chain.  If
+// the checkpointed block was reorganized away, the logs stored after
+// the last block still canonical are deleted, so that they are ingested
+// again, and the checkpoint is moved back, along with the shards past
+// it.  It returns the checkpoint to resume from.
+func (e *backfillEnv) checkReorg(ctx context.Context, state BackfillState, addresses []string) (BackfillState, error) {
+	if state.Status == BackfillStatusNew || state.LastBlockHash == "" {
+		return state, nil
+	}
+
+	canonical, err := e.blockHash(ctx, state.LastBlock)
+	if err != nil {
+		return state, err
+	}
+	if canonical == state.LastBlockHash {
+		return state, nil
+	}
+
+	ancestor, err := e.findCommonAncestor(ctx, addresses, state.LastBlock)
+	if err != nil {
+		return state, err
+	}
+	deleteFrom := ancestor + 1
+	if ancestor < state.CreationBlock {
+		// Nothing survives; start over.
+		ancestor = state.CreationBlock
+		deleteFrom = ancestor
+		state.Status = BackfillStatusNew
+	}
+	hash, err := e.blockHash(ctx, ancestor)
+	if err != nil {
+		return state, err
+	}
+	state.LastBlock = ancestor
+	state.LastBlockHash = hash
+
+	err = e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Every other checkpoint covering these contracts past the
+		// ancestor, under other topics or in a group, is left with a
+		// gap once their logs are deleted, and so is rewound too,
+		// along with the logs of its own contracts.
+		covered, err := coveredAddresses(tx, state.ChainID, addresses, deleteFrom)
+		if err != nil {
+			return err
+		}
+		result := tx.Where("address IN ? AND block_number >= ?", covered, deleteFrom).Delete(&Log{}) //nolint:exhaustruct
+		if result.Error != nil {
+			return fmt.Errorf("delete: %w", result.Error)
+		}
+		// Shards past the ancestor would be taken as scanned, and
+		// those of a rewound checkpoint no longer start where it
+		// stops.  The rest are still contiguous with their checkpoint.
+		var covering BackfillState
+		keys := whereCovers(tx.Model(&covering), covered).
+			Select("address", "topics").
+			Where("chain_id = ?", state.ChainID)
+		result = tx.
+			Where("chain_id = ? AND to_block >= ? AND (address, topics) IN (?)", state.ChainID, deleteFrom, keys).
+			Delete(&BackfillShard{}) //nolint:exhaustruct
+		if result.Error != nil {
+			return fmt.Errorf("delete shards: %w", result.Error)
+		}
+		rewind := map[string]any{"last_block": ancestor, "last_block_hash": hash}
+		if state.Status == BackfillStatusNew {
+			rewind["status"] = BackfillStatusNew
+		}
+		var others BackfillState
+		result = whereCovers(tx.Model(&others), covered).
+			Where("chain_id = ? AND last_block >= ?", state.ChainID, deleteFrom).
+			Updates(rewind)
+		if result.Error != nil {
+			return fmt.Errorf("rewind states: %w", result.Error)
+		}
+		return saveState(ctx, tx, state)
+	})
+	return state, err
+}
+
+// whereCovers restricts `query` to the checkpoints covering any of
+// `addresses`.  Those saved before their addresses were recorded are
+// matched by key, which is the address of a lone contract.
+func whereCovers(query *gorm.DB, addresses []string) *gorm.DB {
+	return query.Where(
+		"(address IN ? OR string_to_array(addresses, ',') && string_to_array(?, ','))",
+		addresses, strings.Join(addresses, ","),
+	)
+}
+
+// coveredAddresses returns `addresses` along with those of every
+// checkpoint past `block` sharing any of them, and so on until no
+// checkpoint adds any.
+func coveredAddresses(tx *gorm.DB, chainID uint64, addresses []string, block uint64) ([]string, error) {
+	covered := slices.Clone(addresses)
+	seen := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		seen[address] = true
+	}
+	for {
+		var xs []BackfillState
+		result := whereCovers(tx, covered).
+			Where("chain_id = ? AND last_block >= ?", chainID, block).
+			Find(&xs)
+		if result.Error != nil {
+			return nil, fmt.Errorf("find states: %w", result.Error)
+		}
+		n := len(covered)
+		for _, x := range xs {
+			for _, address := range x.members() {
+				if !seen[address] {
+					seen[address] = true
+					covered = append(covered, address)
+				}
+			}
+		}
+		if len(covered) == n {
+			return covered, nil
+		}
+	}
+}
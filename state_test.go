@@ -0,0 +1,236 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blocksignalio/core"
+	"github.com/blocksignalio/core/coretest"
+)
+
+// wethKey is the checkpoint key of WETH alone.
+var wethKey = strings.ToLower(coretest.WETH) //nolint:gochecknoglobals
+
+func TestBackfillReorg(t *testing.T) {
+	t.Parallel()
+
+	const head = 10
+
+	tests := []struct {
+		name string
+		// legacy logs are stored without block hash before the first
+		// backfill, as they were before hashes were recorded.
+		legacy []uint64
+		before []uint64
+		// reorg replaces the blocks from `reorg` on with logs at
+		// `after`; no reorg if zero.
+		reorg uint64
+		after []uint64
+		want  []string
+	}{
+		{
+			name:   "canonical",
+			before: []uint64{1, 5, 8},
+			want:   []string{"1/0", "5/0", "8/0"},
+		},
+		{
+			name:   "shallow",
+			before: []uint64{1, 5, 8},
+			reorg:  7,
+			after:  []uint64{7, 9},
+			want:   []string{"1/0", "5/0", "7/0", "9/0"},
+		},
+		{
+			// No stored block survives: start over from the creation
+			// block.
+			name:   "below creation",
+			before: []uint64{1, 2},
+			reorg:  1,
+			after:  []uint64{3},
+			want:   []string{"3/0"},
+		},
+		{
+			// Logs without hash cannot be checked, and are trusted.
+			name:   "legacy",
+			legacy: []uint64{1},
+			before: []uint64{4},
+			reorg:  3,
+			after:  []uint64{5},
+			want:   []string{"1/0", "5/0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFixture(t, head)
+			for _, block := range tt.legacy {
+				f.chain.AddLogs(transfer(block, 0))
+				x := core.FromGethLog(transfer(block, 0))
+				x.BlockHash = ""
+				err := f.db.Create(&x).Error
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, block := range tt.before {
+				f.chain.AddLogs(transfer(block, 0))
+			}
+			f.run(t, coretest.WETH)
+
+			if tt.reorg != 0 {
+				xs := make([]types.Log, len(tt.after))
+				for i, block := range tt.after {
+					xs[i] = transfer(block, 0)
+				}
+				f.chain.Reorg(coretest.WETHCreationBlock+tt.reorg, xs...)
+			}
+			f.run(t, coretest.WETH)
+
+			if diff := cmp.Diff(tt.want, f.logs(t)); diff != "" {
+				t.Errorf("logs (-want +have):\n%s", diff)
+			}
+			state := f.state(t, wethKey)
+			last := f.chain.Head()
+			if state.LastBlock != last || state.LastBlockHash != f.chain.BlockHash(last) {
+				t.Errorf("checkpoint: have=%d/%s want=%d/%s",
+					state.LastBlock, state.LastBlockHash, last, f.chain.BlockHash(last))
+			}
+		})
+	}
+}
+
+func TestBackfillOtherChain(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t, 10)
+	f.chain.AddLogs(transfer(1, 0), transfer(5, 0))
+	f.run(t, coretest.WETH)
+
+	// The same blocks on another chain hash differently, and would be
+	// taken for a reorg.
+	other := coretest.NewChain(2, f.chain.Head())
+	other.AddTransaction(coretest.WETHCreationTx, coretest.WETHCreationBlock)
+	cache := core.NewABICache(f.etherscan.Client(t), core.NewDBStore(f.db), core.DefaultCacheSize)
+	backfiller := core.NewBackfiller(f.db, other.Node(t), cache)
+	backfiller.Options.Confirmations = 0
+	err := backfiller.BackfillGroup(context.Background(), []string{coretest.WETH})
+	if !errors.Is(err, core.ErrChainMismatch) {
+		t.Errorf("error: have=%v want=%v", err, core.ErrChainMismatch)
+	}
+	if diff := cmp.Diff([]string{"1/0", "5/0"}, f.logs(t)); diff != "" {
+		t.Errorf("logs (-want +have):\n%s", diff)
+	}
+}
+
+func TestBackfillResume(t *testing.T) {
+	t.Parallel()
+
+	// Without any log, only the checkpoint tells where to resume.
+	f := newFixture(t, 10)
+	f.run(t, coretest.WETH)
+	calls := f.etherscan.Calls()
+	scanned := len(f.chain.Queries())
+
+	last := f.chain.Head()
+	f.chain.SetHead(last + 5)
+	f.run(t, coretest.WETH)
+	if have := f.etherscan.Calls(); have != calls {
+		t.Errorf("etherscan calls: have=%d want=%d", have, calls)
+	}
+	for _, q := range f.chain.Queries()[scanned:] {
+		if q[0] <= last {
+			t.Errorf("rescanned: [%d, %d], last scanned %d", q[0], q[1], last)
+		}
+	}
+	if have, want := f.state(t, wethKey).LastBlock, f.chain.Head(); have != want {
+		t.Errorf("checkpoint: have=%d want=%d", have, want)
+	}
+	if have := f.count(t, &core.Log{}); have != 0 { //nolint:exhaustruct
+		t.Errorf("logs: have=%d want=0", have)
+	}
+}
+
+func TestBackfillResumeFailed(t *testing.T) {
+	t.Parallel()
+
+	// The first batch fails: nothing was scanned yet.
+	f := newFixture(t, 10)
+	f.chain.AddLogs(transfer(1, 0))
+	f.failDecoding(t, coretest.WETHCreationBlock+1)
+	results := f.backfiller.Run(context.Background(), []string{coretest.WETH})
+	if results[0].Err == nil {
+		t.Fatal("error: have=<nil> want an error")
+	}
+	if have := f.state(t, wethKey).Status; have != core.BackfillStatusFailed {
+		t.Errorf("status: have=%s want=%s", have, core.BackfillStatusFailed)
+	}
+
+	f.heal(t)
+	scanned := len(f.chain.Queries())
+	f.run(t, coretest.WETH)
+	for _, q := range f.chain.Queries()[scanned:] {
+		if q[0] < coretest.WETHCreationBlock {
+			t.Errorf("scanned before creation: [%d, %d]", q[0], q[1])
+		}
+	}
+	if diff := cmp.Diff([]string{"1/0"}, f.logs(t)); diff != "" {
+		t.Errorf("logs (-want +have):\n%s", diff)
+	}
+}
+
+func TestBackfillReorgOverlap(t *testing.T) {
+	t.Parallel()
+
+	const (
+		usdc         = "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+		usdcCreation = "0x00000000000000000000000000000000000000000000000000000000000000c1"
+		approval     = "0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"
+	)
+
+	f := newFixture(t, 5)
+	f.etherscan.AddCreation(usdc, coretest.WETHCreator, usdcCreation)
+	f.chain.AddTransaction(usdcCreation, coretest.WETHCreationBlock+1)
+	f.chain.AddLogs(transfer(2, 0))
+
+	// The transfers of a group including WETH, and all of WETH's logs,
+	// have checkpoints of their own.
+	group := func() {
+		t.Helper()
+		f.backfiller.Options.Topics = [][]string{{transferTopic}}
+		defer func() { f.backfiller.Options.Topics = nil }()
+		err := f.backfiller.BackfillGroup(context.Background(), []string{coretest.WETH, usdc})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	group()
+	f.run(t, coretest.WETH)
+
+	// Both are past the reorg.  The WETH checkpoint catches up first,
+	// storing an approval the group does not cover.
+	f.chain.Reorg(coretest.WETHCreationBlock + 4)
+	f.chain.AddLogs(coretest.MakeLog(coretest.WETH, coretest.WETHCreationBlock+8, 0, approval))
+	f.chain.SetHead(coretest.WETHCreationBlock + 10)
+	f.run(t, coretest.WETH)
+	group()
+
+	if diff := cmp.Diff([]string{"2/0", "8/0"}, f.logs(t)); diff != "" {
+		t.Errorf("logs (-want +have):\n%s", diff)
+	}
+	var states []core.BackfillState
+	err := f.db.Find(&states).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if have, want := state.LastBlockHash, f.chain.BlockHash(state.LastBlock); have != want {
+			t.Errorf("checkpoint %s at %d: have=%s want=%s", state.Address, state.LastBlock, have, want)
+		}
+	}
+}
@@ -11,10 +11,26 @@ var (
 	ErrInvalidContractAddress = errors.New("invalid contract address")
 	ErrUnsetEnvironmentVar    = errors.New("environment variable not set")
 	ErrNegativePage           = errors.New("page cannot be negative")
+	ErrPageOutOfRange         = errors.New("page out of range")
 	ErrInvalidTopic           = errors.New("invalid topic")
+	ErrTooManyTopics          = errors.New("too many topics")
+	ErrInvalidCursor          = errors.New("invalid cursor")
+	ErrInvalidTxHash          = errors.New("invalid transaction hash")
+	ErrInvalidBlockRange      = errors.New("invalid block range")
+	ErrChainMismatch          = errors.New("database holds another chain")
 
 	ErrInvalidResponse     = errors.New("invalid response")
 	ErrInvalidResponseBody = errors.New("invalid response body")
+
+	ErrRateLimited         = errors.New("rate limit reached")
+	ErrInvalidAPIKey       = errors.New("invalid api key")
+	ErrContractNotVerified = errors.New("contract source code not verified")
+	ErrNotFound            = errors.New("no data found")
+
+	ErrCacheMiss = errors.New("cache miss")
+
+	ErrUnknownEvent   = errors.New("unknown event")
+	ErrUndecodableLog = errors.New("undecodable log")
 )
 
 func has0xPrefix(str string) bool {
@@ -32,7 +32,7 @@ func TestSanitizeHex(t *testing.T) {
 		"0xC02AAA39B223FE8D0A0E5C4F27EAD9083C756CC2",
 		"0x0C02AAA39B223FE8D0A0E5C4F27EAD9083C756CC2",
 		"0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2F",
-		"0xeeaeaeaaafee",
+		"0xeeaeaeaaafea",
 		"0xfcdd",
 		"0xecee31ee0",
 		"",